@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// adminDeadLettersPath is the prefix of admin HTTP endpoints of dead letters:
+//
+//	GET    /admin/dead-letters           list dead letters
+//	GET    /admin/dead-letters/ID        inspect dead letter
+//	POST   /admin/dead-letters/ID/retry  retry dead letter
+//	DELETE /admin/dead-letters/ID        discard dead letter
+const adminDeadLettersPath = "/admin/dead-letters"
+
+// handleAdmin registers admin HTTP endpoints if admin token is configured
+func (s *Server) handleAdmin(mux *http.ServeMux) {
+	if s.Config.AdminToken == "" {
+		return
+	}
+	handler := s.adminAuth(newSecret(s.Config.AdminToken), http.HandlerFunc(s.serveDeadLetters))
+	mux.Handle(adminDeadLettersPath, handler)
+	mux.Handle(adminDeadLettersPath+"/", handler)
+}
+
+// adminAuth only passes requests with the admin bearer token
+func (s *Server) adminAuth(token *secret, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, err := token.Value()
+		if err != nil {
+			logrus.WithError(err).Error("Error reading admin token")
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(value)) != 1 {
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !s.track() {
+		http.Error(w, "503 Service Unavailable: shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.wg.Done()
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, adminDeadLettersPath), "/")
+	id, action := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		id, action = path[:i], path[i+1:]
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		letters, err := s.deadLetters.list()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeAdminJSON(w, letters)
+	case id != "" && action == "" && r.Method == http.MethodGet:
+		letter, err := s.deadLetters.get(id)
+		if err != nil {
+			http.Error(w, err.Error(), adminErrorStatus(err))
+			return
+		}
+		writeAdminJSON(w, letter)
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		if err := s.deadLetters.remove(id); err != nil {
+			http.Error(w, err.Error(), adminErrorStatus(err))
+			return
+		}
+		logrus.WithField("dead-letter", id).Info("discard dead letter")
+		w.WriteHeader(http.StatusNoContent)
+	case id != "" && action == "retry" && r.Method == http.MethodPost:
+		if err := s.retryDeadLetter(id); err != nil {
+			http.Error(w, err.Error(), adminErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+	}
+}
+
+func adminErrorStatus(err error) int {
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logrus.WithError(err).Warn("Error writing admin response")
+	}
+}
+
+// runDeadLetter lists, inspects, retries or discards dead letters, e.g.
+// `sync-jira dead-letter retry ID...`
+func runDeadLetter(config *Config) int {
+	args := config.FlagSet.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "action should be given: list, inspect, retry, discard")
+		return exitUsage
+	}
+	action, ids := args[0], args[1:]
+	if config.DeadLetterDir == "" {
+		fmt.Fprintln(os.Stderr, "dead-letter-dir should be given")
+		return exitUsage
+	}
+	store := newDeadLetterStore(config.DeadLetterDir)
+
+	switch action {
+	case "list":
+		letters, err := store.list()
+		if err != nil {
+			logrus.WithError(err).Error("Error listing dead letters")
+			return exitFailure
+		}
+		writeDeadLetters(os.Stdout, letters)
+		return exitOK
+	case "inspect":
+		failed := 0
+		for _, id := range ids {
+			letter, err := store.get(id)
+			if err != nil {
+				fmt.Printf("FAIL  %s: %v\n", id, err)
+				failed++
+				continue
+			}
+			b, _ := json.MarshalIndent(letter, "", "  ")
+			fmt.Println(string(b))
+		}
+		return deadLetterExitCode(failed)
+	case "discard":
+		failed := 0
+		for _, id := range ids {
+			if err := store.remove(id); err != nil {
+				fmt.Printf("FAIL  %s: %v\n", id, err)
+				failed++
+				continue
+			}
+			fmt.Printf("ok    %s discarded\n", id)
+		}
+		return deadLetterExitCode(failed)
+	case "retry":
+		server, err := newServer(config)
+		if err != nil {
+			logrus.WithError(err).Error("Error creating server")
+			return exitFailure
+		}
+		// all dead letters of events and issues are retried if none is given
+		if len(ids) == 0 {
+			letters, err := server.deadLetters.list()
+			if err != nil {
+				logrus.WithError(err).Error("Error listing dead letters")
+				return exitFailure
+			}
+			for _, letter := range letters {
+				if letter.Kind != deadLetterRequest {
+					ids = append(ids, letter.ID)
+				}
+			}
+		}
+		server.watchSignals()
+		failed := 0
+		for _, id := range ids {
+			// dead letters not retried yet are left on shutdown
+			if server.stopping() {
+				break
+			}
+			if err := server.retryDeadLetter(id); err != nil {
+				fmt.Printf("FAIL  %s: %v\n", id, err)
+				failed++
+				continue
+			}
+			fmt.Printf("ok    %s retried\n", id)
+		}
+		server.writeDryRunReport()
+		server.saveState()
+		if failed == 0 && server.stopping() {
+			return exitInterrupted
+		}
+		return deadLetterExitCode(failed)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown action '%s', use list, inspect, retry, discard\n", action)
+		return exitUsage
+	}
+}
+
+func deadLetterExitCode(failed int) int {
+	if failed != 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func writeDeadLetters(w io.Writer, letters []*deadLetter) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tKIND\tATTEMPTS\tLAST FAILED\tERROR\n")
+	for _, letter := range letters {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", letter.ID, letter.Kind, letter.Attempts,
+			letter.LastFailedAt.Format(time.RFC3339), oneLine(letter.Error))
+	}
+	tw.Flush()
+}
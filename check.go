@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// runCheck verifies credentials of all GitHub endpoints and JIRA targets, access
+// to configured repos and organizations, and JIRA projects they synchronize to
+func runCheck(config *Config) int {
+	// credentials of JIRA targets and GitHub App installations are checked here
+	server, err := newServer(config)
+	if err != nil {
+		fmt.Printf("FAIL  connect GitHub and JIRA: %v\n", err)
+		return exitCheckFailed
+	}
+
+	failed := 0
+	report := func(what string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", what, err)
+			return
+		}
+		fmt.Printf("ok    %s\n", what)
+	}
+
+	ctx := context.Background()
+
+	endpoints := make([]string, 0, len(server.githubClients))
+	for name := range server.githubClients {
+		endpoints = append(endpoints, name)
+	}
+	sort.Strings(endpoints)
+	for _, name := range endpoints {
+		githubClient := server.githubClientOf(name)
+		_, _, err := githubClient.RateLimits(ctx)
+		report(fmt.Sprintf("GitHub endpoint %s", githubClient.BaseURL), err)
+
+		for _, owner := range config.routeOwners(name) {
+			_, _, err := githubClient.Organizations.Get(ctx, owner)
+			report(fmt.Sprintf("GitHub organization %s", owner), err)
+		}
+	}
+
+	repoConfigs := make([]RepoConfig, 0, len(config.RepoConfigMap)+len(config.Routes))
+	keys := make([]string, 0, len(config.RepoConfigMap))
+	for key := range config.RepoConfigMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		repoConfig := config.RepoConfigMap[key]
+		_, _, err := server.githubClientOf(repoConfig.GithubEndpoint).Repositories.Get(ctx, repoConfig.GithubOwner, repoConfig.name)
+		report(fmt.Sprintf("GitHub repo %s", key), err)
+		repoConfigs = append(repoConfigs, repoConfig)
+	}
+	for _, route := range config.Routes {
+		repoConfigs = append(repoConfigs, route.RepoConfig)
+	}
+
+	// each JIRA project is checked once per target
+	checked := map[string]bool{}
+	for _, repoConfig := range repoConfigs {
+		key := repoConfig.JiraTarget + ":" + repoConfig.JiraProjectKey
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		jiraTarget := server.jiraTargetOf(repoConfig)
+		_, _, err := jiraTarget.client.Project.Get(repoConfig.JiraProjectKey)
+		report(fmt.Sprintf("JIRA project %s of %s", repoConfig.JiraProjectKey, jiraTarget.config.BaseURL), err)
+	}
+
+	if failed != 0 {
+		logrus.Errorf("%d checks failed", failed)
+		return exitCheckFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// exit codes of commands
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitDrift       = 3 // reconcile found drift between GitHub and JIRA
+	exitCheckFailed = 4 // check found unusable credentials or permissions
+	exitInterrupted = 5 // stopped by SIGINT or SIGTERM before finishing
+)
+
+// command is a subcommand of sync-jira, e.g. `sync-jira sync -repo pingcap/tidb`
+type command struct {
+	name  string
+	usage string
+	// whether positional arguments are accepted after flags
+	acceptArgs bool
+	// setFlags registers flags of the command besides the global ones
+	setFlags func(fs *flag.FlagSet)
+	run      func(config *Config) int
+}
+
+func commands() []*command {
+	return []*command{
+		{
+			name:  "serve",
+			usage: "listen to GitHub webhooks only",
+			run:   runServe,
+		},
+		newSyncCommand(),
+		newReconcileCommand(),
+		{
+			name:  "check",
+			usage: "check credentials, repo access and JIRA project permissions",
+			run:   runCheck,
+		},
+		{
+			name:       "replay",
+			usage:      "replay stored webhook deliveries, files or directories are given as arguments",
+			acceptArgs: true,
+			run:        runReplay,
+		},
+		{
+			name:       "dead-letter",
+			usage:      "list, inspect, retry or discard failed events, issues and requests, e.g. dead-letter retry ID",
+			acceptArgs: true,
+			run:        runDeadLetter,
+		},
+		{
+			name:       "cursor",
+			usage:      "show, set or reset per-repo sync cursors, e.g. cursor set owner/name 72h",
+			acceptArgs: true,
+			run:        runCursor,
+		},
+	}
+}
+
+// runCommand runs the subcommand given as the first argument, without one it
+// runs the pre-synchronization if enabled and then serves webhooks as before
+func runCommand(args []string) int {
+	cmd := &command{name: "", run: runLegacy}
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = nil
+		for _, c := range commands() {
+			if c.name == args[0] {
+				cmd = c
+			}
+		}
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", args[0])
+			printCommandsUsage()
+			return exitUsage
+		}
+		args = args[1:]
+	}
+
+	config := NewConfig()
+	if cmd.name != "" {
+		config.FlagSet.Init("sync-jira "+cmd.name, flag.ContinueOnError)
+	} else {
+		config.FlagSet.Usage = func() {
+			printCommandsUsage()
+			config.FlagSet.PrintDefaults()
+		}
+	}
+	if cmd.setFlags != nil {
+		cmd.setFlags(config.FlagSet)
+	}
+	config.acceptArgs = cmd.acceptArgs
+
+	if err := config.Parse(args); err != nil {
+		logrus.Errorf("verifying flags error %s", errors.ErrorStack(err))
+		return exitUsage
+	}
+	logrusSetLevelByString(config.LogLevel)
+
+	// output of one-shot commands is the report on stdout, logs go to stderr
+	switch cmd.name {
+	case "", "serve":
+		PrintVersionInfo()
+	default:
+		logrus.SetOutput(os.Stderr)
+	}
+	// credentials are redacted by Config.String()
+	logrus.Debugf("\nConfig is: %+v\n", config)
+
+	return cmd.run(config)
+}
+
+func printCommandsUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: sync-jira [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nWithout command, sync-jira runs pre-synchronization and serves GitHub webhooks.")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func runLegacy(config *Config) int {
+	// create new server
+	server, err := newServer(config)
+	if err != nil {
+		logrus.WithError(err).Error("Error creating server")
+		return exitFailure
+	}
+	server.watchSignals()
+
+	// compare and sync issues to JIRA before the server start to listen
+	// !! there is corner case when doing this, new webhook events arrive
+	if server.Config.DoPreSync {
+		// issues failed to synchronize are dead letters, which do not stop
+		// serving webhooks, interrupted presync is followed by shutdown in serve
+		_, err := server.presync(nil)
+		server.writeDryRunReport()
+		if err != nil && err != errShuttingDown {
+			return exitFailure
+		}
+	} else {
+		logrus.Info("bypass presync")
+	}
+
+	return server.serve()
+}
+
+func runServe(config *Config) int {
+	server, err := newServer(config)
+	if err != nil {
+		logrus.WithError(err).Error("Error creating server")
+		return exitFailure
+	}
+	server.watchSignals()
+	return server.serve()
+}
+
+// serve starts server to listen to github webhook, it returns on error or after
+// graceful shutdown on SIGINT or SIGTERM
+func (s *Server) serve() int {
+	mux := http.NewServeMux()
+	mux.Handle("/", s)
+	s.handleAdmin(mux)
+	httpServer := &http.Server{
+		Addr:    ":" + strconv.Itoa(s.Config.ListenPort),
+		Handler: mux,
+	}
+
+	if s.Config.hasSyncSchedule() && s.track() {
+		// schedule is validated when config is parsed, nil if only some repos
+		// have their own
+		var sched schedule
+		if s.Config.SyncSchedule != "" {
+			sched, _ = parseSchedule(s.Config.SyncSchedule)
+		}
+		go func() {
+			defer s.wg.Done()
+			s.runScheduledSyncs(sched, s.stop)
+		}()
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- httpServer.ListenAndServe()
+	}()
+
+	code := exitOK
+	select {
+	case err := <-errc:
+		logrus.WithError(err).Error("Error serving webhooks")
+		code = exitFailure
+	case <-s.stop:
+	}
+	if err := s.shutdown(httpServer); err != nil {
+		code = exitFailure
+	}
+	return code
+}
+
+// errPresyncRunning is returned when presync is started while the previous one is running
+var errPresyncRunning = errors.New("presync is already running")
+
+// presync compares and syncs issues of repos, or all repos if not given, from GitHub
+// to JIRA, it returns the number of issues failed to synchronize and fatal errors
+func (s *Server) presync(repos []string) (int, error) {
+	if !atomic.CompareAndSwapInt32(&s.presyncRunning, 0, 1) {
+		return 0, errPresyncRunning
+	}
+	defer atomic.StoreInt32(&s.presyncRunning, 0)
+
+	start := time.Now()
+	logrus.Info("start compare and sync issues from GitHub to JIRA")
+	l := logrus.WithFields(logrus.Fields{
+		"event-type": "compareSyncIssues",
+	},
+	)
+	failed, err := s.compareSyncIssues(l, repos)
+	if err != nil {
+		logrus.WithError(err).Error("compare sync issuues failed")
+		return failed, err
+	}
+	if failed != 0 {
+		logrus.Warnf("finish compare and sync issues from GitHub to JIRA, %d issues failed", failed)
+	} else {
+		logrus.Info("success compare and sync issues from GitHub to JIRA")
+	}
+	logrus.Infof("which took about %v", time.Since(start))
+	return failed, nil
+}
+
+// writeDryRunReport writes planned JIRA actions to stdout in dry-run mode
+func (s *Server) writeDryRunReport() {
+	if s.dryRunPlan == nil {
+		return
+	}
+	if err := s.dryRunPlan.writeReport(os.Stdout, s.Config.DryRunFormat); err != nil {
+		logrus.WithError(err).Error("Error writing dry-run report")
+	}
+}
+
+// repoList is a flag of comma separated repos, e.g. "pingcap/tidb,ghe:infra/deploy"
+type repoList []string
+
+func (repos *repoList) String() string {
+	return strings.Join(*repos, ",")
+}
+
+func (repos *repoList) Set(value string) error {
+	for _, repo := range strings.Split(value, ",") {
+		repo = strings.TrimSpace(repo)
+		if repo == "" {
+			continue
+		}
+		if !strings.Contains(repo, "/") {
+			return errors.Errorf("'%s' is an invalid repo, use owner/name", repo)
+		}
+		*repos = append(*repos, repo)
+	}
+	return nil
+}
+
+// sinceTime is a flag of RFC3339 time or duration before now, e.g. "72h"
+type sinceTime struct {
+	time.Time
+}
+
+func (since *sinceTime) String() string {
+	if since.IsZero() {
+		return ""
+	}
+	return since.Format(time.RFC3339)
+}
+
+func (since *sinceTime) Set(value string) error {
+	if d, err := time.ParseDuration(value); err == nil {
+		since.Time = time.Now().Add(-d)
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return errors.Errorf("'%s' is neither RFC3339 time nor duration", value)
+	}
+	since.Time = t
+	return nil
+}
+
+// setSince makes GitHub issues updated since the given time to be synchronized,
+// instead of the sync cursors of repos
+func (config *Config) setSince(since sinceTime) {
+	if !since.IsZero() {
+		config.GithubIssueSince = since.Time
+		config.UseSyncCursors = false
+	}
+}
+
+func newSyncCommand() *command {
+	var repos repoList
+	var since sinceTime
+	return &command{
+		name:  "sync",
+		usage: "synchronize GitHub issues to JIRA once and exit",
+		setFlags: func(fs *flag.FlagSet) {
+			fs.Var(&repos, "repo", "comma separated repos to synchronize, owner/name or endpoint:owner/name, all repos if not given")
+			fs.Var(&since, "since", "synchronize issues updated since RFC3339 time or duration before now, e.g. 72h")
+		},
+		run: func(config *Config) int {
+			config.setSince(since)
+			server, err := newServer(config)
+			if err != nil {
+				logrus.WithError(err).Error("Error creating server")
+				return exitFailure
+			}
+			server.watchSignals()
+			failed, err := server.presync(repos)
+			server.writeDryRunReport()
+			server.saveState()
+			if err == errShuttingDown {
+				return exitInterrupted
+			}
+			if err != nil || failed != 0 {
+				return exitFailure
+			}
+			return exitOK
+		},
+	}
+}
+
+func runReplay(config *Config) int {
+	if config.FlagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "stored webhook delivery files or directories should be given")
+		return exitUsage
+	}
+	deliveries, err := loadWebhookDeliveries(config.FlagSet.Args())
+	if err != nil {
+		logrus.WithError(err).Error("Error loading webhook deliveries")
+		return exitFailure
+	}
+
+	server, err := newServer(config)
+	if err != nil {
+		logrus.WithError(err).Error("Error creating server")
+		return exitFailure
+	}
+
+	server.watchSignals()
+	replayed, failed := server.replayWebhookDeliveries(deliveries)
+	server.writeDryRunReport()
+	server.saveState()
+	fmt.Printf("replayed %d of %d webhook deliveries, %d failed\n", replayed, len(deliveries), failed)
+	if failed != 0 {
+		return exitFailure
+	}
+	if replayed != len(deliveries) {
+		return exitInterrupted
+	}
+	return exitOK
+}
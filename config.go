@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"reflect"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -14,6 +15,11 @@ import (
 
 //RepoConfig store repo related information
 type RepoConfig struct {
+	// name of GitHub endpoint in Config.GithubEndpoints, empty is github.com
+	GithubEndpoint string `toml:"github-endpoint,omitempty" json:"github-endpoint,omitempty"`
+	// name of JIRA target in Config.JiraTargets, empty is the global JIRA
+	JiraTarget string `toml:"jira-target,omitempty" json:"jira-target,omitempty"`
+
 	GithubOwner       string              `toml:"github-owner" json:"github-owner"`
 	JiraProjectKey    string              `toml:"jira-project" json:"jira-project"`
 	JiraComponents    []string            `toml:"jira-components,omitempty" json:"jira-components,omitempty"`
@@ -21,6 +27,98 @@ type RepoConfig struct {
 	IssueTypeLabelMap map[string]string   `toml:"issuetype-label-map,omitempty" json:"issuetype-label-map,omitempty"`
 	ComponentLabelMap map[string]string   `toml:"component-label-map,omitempty" json:"component-label-map,omitempty"`
 	TransitionMap     map[string][]string `toml:"transition-map,omitempty" json:"transition-map,omitempty"`
+
+	// Go templates of JIRA issue summary, description and comment, see template.go
+	SummaryTemplate     string `toml:"summary-template,omitempty" json:"summary-template,omitempty"`
+	DescriptionTemplate string `toml:"description-template,omitempty" json:"description-template,omitempty"`
+	CommentTemplate     string `toml:"comment-template,omitempty" json:"comment-template,omitempty"`
+
+	// regexps removed from GitHub title before rendering summary, e.g. "^\\[BUG\\]\\s*"
+	SummaryStripPatterns []string `toml:"summary-strip-patterns,omitempty" json:"summary-strip-patterns,omitempty"`
+	// GitHub label to summary tag map, the first matched tag is .LabelTag in templates
+	SummaryLabelTags map[string]string `toml:"summary-label-tags,omitempty" json:"summary-label-tags,omitempty"`
+
+	// override the global timezone and timestamp format of this repo
+	Timezone        string `toml:"timezone,omitempty" json:"timezone,omitempty"`
+	TimestampFormat string `toml:"timestamp-format,omitempty" json:"timestamp-format,omitempty"`
+
+	// decide which GitHub issues are synchronized, see filter.go
+	Filter IssueFilterConfig `toml:"filter,omitempty" json:"filter,omitempty"`
+
+	// create JIRA issue when events arrive for GitHub issues never synchronized
+	AutoCreate bool `toml:"auto-create,omitempty" json:"auto-create,omitempty"`
+
+	// override the global sync-schedule of this repo
+	SyncSchedule string `toml:"sync-schedule,omitempty" json:"sync-schedule,omitempty"`
+
+	// following are filled when config is parsed, see repo.go
+	name      string // GitHub repo name without owner
+	loc       *time.Location
+	templates *jiraTemplates
+	filter    *issueFilter
+	schedule  schedule
+}
+
+// IssueFilterConfig decides which GitHub issues are synchronized, an issue is
+// synchronized when it matches all of the include rules and none of the exclude
+// rules, rules not given are ignored. Labels, authors and associations match any
+// of the list case insensitively, titles match any of the regexps
+type IssueFilterConfig struct {
+	IncludeLabels        []string `toml:"include-labels,omitempty" json:"include-labels,omitempty"`
+	ExcludeLabels        []string `toml:"exclude-labels,omitempty" json:"exclude-labels,omitempty"`
+	IncludeAuthors       []string `toml:"include-authors,omitempty" json:"include-authors,omitempty"`
+	ExcludeAuthors       []string `toml:"exclude-authors,omitempty" json:"exclude-authors,omitempty"`
+	ExcludeBots          bool     `toml:"exclude-bots,omitempty" json:"exclude-bots,omitempty"`
+	IncludeTitlePatterns []string `toml:"include-title-patterns,omitempty" json:"include-title-patterns,omitempty"`
+	ExcludeTitlePatterns []string `toml:"exclude-title-patterns,omitempty" json:"exclude-title-patterns,omitempty"`
+
+	// author association to repo: OWNER, MEMBER, COLLABORATOR, CONTRIBUTOR,
+	// FIRST_TIME_CONTRIBUTOR, FIRST_TIMER or NONE
+	IncludeAssociations []string `toml:"include-associations,omitempty" json:"include-associations,omitempty"`
+	ExcludeAssociations []string `toml:"exclude-associations,omitempty" json:"exclude-associations,omitempty"`
+}
+
+// RouteConfig routes GitHub repos of an owner matching the pattern to a JIRA
+// project, the settings are the same as RepoConfig
+type RouteConfig struct {
+	// glob pattern of GitHub repo names, e.g. "tidb-*", empty pattern matches all repos of owner
+	RepoPattern string `toml:"repo-pattern,omitempty" json:"repo-pattern,omitempty"`
+	// use GitHub repo name as JIRA component instead of jira-components
+	ComponentFromRepo bool `toml:"component-from-repo,omitempty" json:"component-from-repo,omitempty"`
+
+	RepoConfig
+}
+
+// GithubEndpointConfig is a GitHub instance other than github.com, e.g. GitHub
+// Enterprise Server, with its own credentials
+type GithubEndpointConfig struct {
+	BaseURL   string `toml:"base-url" json:"base-url"`     // API URL, e.g. "https://github.example.com/api/v3/"
+	UploadURL string `toml:"upload-url" json:"upload-url"` // e.g. "https://github.example.com/api/uploads/"
+	// host where webhooks come from, default is host of base URL
+	Host string `toml:"host,omitempty" json:"host,omitempty"`
+
+	Username      string `toml:"username,omitempty" json:"username,omitempty"`
+	Password      string `toml:"password,omitempty" json:"password,omitempty" secret:"true"`
+	Token         string `toml:"token,omitempty" json:"token,omitempty" secret:"true"`
+	AppID         int64  `toml:"app-id,omitempty" json:"app-id,omitempty"`
+	AppPrivateKey string `toml:"app-private-key,omitempty" json:"app-private-key,omitempty" secret:"true"`
+}
+
+// JiraTargetConfig is a JIRA instance other than the global one, with its own
+// URL and authentication, options are the same as the global "jira-*" ones
+type JiraTargetConfig struct {
+	BaseURL    string `toml:"baseurl" json:"baseurl"`
+	Deployment string `toml:"deployment,omitempty" json:"deployment,omitempty"`
+	DocFormat  string `toml:"doc-format,omitempty" json:"doc-format,omitempty"`
+
+	Auth             string `toml:"auth,omitempty" json:"auth,omitempty"`
+	Username         string `toml:"username,omitempty" json:"username,omitempty"`
+	Password         string `toml:"password,omitempty" json:"password,omitempty" secret:"true"`
+	APIToken         string `toml:"api-token,omitempty" json:"api-token,omitempty" secret:"true"`
+	Token            string `toml:"token,omitempty" json:"token,omitempty" secret:"true"`
+	OAuthConsumerKey string `toml:"oauth-consumer-key,omitempty" json:"oauth-consumer-key,omitempty"`
+	OAuthPrivateKey  string `toml:"oauth-private-key,omitempty" json:"oauth-private-key,omitempty" secret:"true"`
+	OAuthAccessToken string `toml:"oauth-access-token,omitempty" json:"oauth-access-token,omitempty" secret:"true"`
 }
 
 // Config is config for the server
@@ -28,35 +126,103 @@ type Config struct {
 	*flag.FlagSet
 
 	configFile string
+	// positional arguments are only accepted by some subcommands
+	acceptArgs bool
 	LogLevel   string `toml:"log-level" json:"log-level"`
 
 	Version bool `json:"-"`
 
 	ListenPort     int    `toml:"listen-port" json:"listen-port"`
 	GithubUsername string `toml:"github-username" json:"github-username"`
-	GithubPassword string `toml:"github-password" json:"github-password"`
-	JiraUsername   string `toml:"jira-username" json:"jira-username"`
-	JiraPassword   string `toml:"jira-password" json:"jira-password"`
-	JiraBaseURL    string `toml:"jira-baseurl" json:"jira-baseurl"`
+	GithubPassword string `toml:"github-password" json:"github-password" secret:"true"`
+	GithubToken    string `toml:"github-token" json:"github-token" secret:"true"`
+
+	// GitHub App authentication, installation tokens are minted per repo owner
+	GithubAppID         int64  `toml:"github-app-id" json:"github-app-id"`
+	GithubAppPrivateKey string `toml:"github-app-private-key" json:"github-app-private-key" secret:"true"`
+
+	// other GitHub instances by name, which repos and routes could refer to
+	GithubEndpoints map[string]GithubEndpointConfig `toml:"github-endpoint" json:"github-endpoint"`
+
+	JiraUsername string `toml:"jira-username" json:"jira-username"`
+	JiraPassword string `toml:"jira-password" json:"jira-password" secret:"true"`
+	JiraBaseURL  string `toml:"jira-baseurl" json:"jira-baseurl"`
+
+	// JIRA deployment type, "server" or "cloud", cloud uses accountId and API token
+	JiraDeployment string `toml:"jira-deployment" json:"jira-deployment"`
+	JiraAPIToken   string `toml:"jira-api-token" json:"jira-api-token" secret:"true"`
+
+	// JIRA authentication mode, "basic", "token" (personal access token) or "oauth1"
+	JiraAuth             string `toml:"jira-auth" json:"jira-auth"`
+	JiraToken            string `toml:"jira-token" json:"jira-token" secret:"true"`
+	JiraOAuthConsumerKey string `toml:"jira-oauth-consumer-key" json:"jira-oauth-consumer-key"`
+	JiraOAuthPrivateKey  string `toml:"jira-oauth-private-key" json:"jira-oauth-private-key" secret:"true"`
+	JiraOAuthAccessToken string `toml:"jira-oauth-access-token" json:"jira-oauth-access-token" secret:"true"`
+
+	// JIRA description and comment format, "wiki" or "adf", adf uses API v3 endpoints
+	JiraDocFormat string `toml:"jira-doc-format" json:"jira-doc-format"`
+
+	// other JIRA instances by name, which repos and routes could refer to
+	JiraTargets map[string]JiraTargetConfig `toml:"jira-target" json:"jira-target"`
 
 	DoPreSync bool `toml:"do-presync" json:"do-presync"`
 
+	// synchronize issues updated since the last synchronization of each repo
+	// periodically while serving webhooks, a Go duration e.g. "30m" or a cron
+	// expression e.g. "0 */6 * * *", disabled if empty
+	SyncSchedule string `toml:"sync-schedule" json:"sync-schedule"`
+
+	// how long to wait for in-flight webhooks and synchronization on SIGINT or
+	// SIGTERM before exiting anyway, a Go duration e.g. "30s"
+	ShutdownTimeout string `toml:"shutdown-timeout" json:"shutdown-timeout"`
+	shutdownTimeout time.Duration
+
+	// number of issues synchronized in parallel by presync, and the maximum
+	// concurrent requests to each GitHub endpoint and each JIRA target
+	PresyncWorkers    int `toml:"presync-workers" json:"presync-workers"`
+	GithubConcurrency int `toml:"github-concurrency" json:"github-concurrency"`
+	JiraConcurrency   int `toml:"jira-concurrency" json:"jira-concurrency"`
+
+	// directory to store received webhook deliveries for replay, optional
+	PayloadDir string `toml:"payload-dir" json:"payload-dir"`
+
+	// directory to keep failed events, issues and requests for retry, relative to
+	// the working directory, they are only logged if empty, the default
+	DeadLetterDir string `toml:"dead-letter-dir" json:"dead-letter-dir"`
+	// bearer token of admin HTTP endpoints, which are disabled if empty
+	AdminToken string `toml:"admin-token" json:"admin-token" secret:"true"`
+
+	// resume each repo from its sync cursor saved by previous runs, instead of
+	// github-sincetime
+	UseSyncCursors bool `toml:"use-sync-cursors" json:"use-sync-cursors"`
+	// deprecated, the same as use-sync-cursors
 	UseLastSyncTimeFile bool `toml:"use-lastsynctimefile" json:"use-lastsynctimefile"`
 
+	// record JIRA mutations as planned actions without sending them, the report
+	// of planned actions is written in "text" or "json"
+	DryRun       bool   `toml:"dry-run" json:"dry-run"`
+	DryRunFormat string `toml:"dry-run-format" json:"dry-run-format"`
+
 	GithubIssueSince time.Time `toml:"github-sincetime" json:"github-sincetime"`
 
+	// timezone and format of timestamps in JIRA issue and comment footers, format
+	// is a Go time layout or "iso8601"
+	Timezone        string `toml:"timezone" json:"timezone"`
+	TimestampFormat string `toml:"timestamp-format" json:"timestamp-format"`
+
 	Loc *time.Location
 
-	// GitHub repo name to JIRA project config map
+	// GitHub repo "owner/name" to JIRA project config map, keys are lower case
+	// after config is parsed, bare repo name keys of old config are still accepted
 	RepoConfigMap map[string]RepoConfig `toml:"repo" json:"repo"`
 
+	// routing rules of repos not in RepoConfigMap, evaluated in order
+	Routes []RouteConfig `toml:"route" json:"route"`
+
 	// JIRA related map
 	FixVersions     map[string][]string `toml:"fix-versions,omitempty" json:"fix-versions,omitempty"`
 	AffectsVersions map[string][]string `toml:"affects-versions,omitempty" json:"affects-versions,omitempty"`
 	AssigneeMap     map[string]string   `toml:"assignee,omitempty" json:"assignee,omitempty"`
-
-	// JIRA custom field keys map
-	FieldIDs map[fieldKey]string
 }
 
 // NewConfig create new config
@@ -70,19 +236,43 @@ func NewConfig() *Config {
 	fs.IntVar(&config.ListenPort, "listen-port", 8080, "port used to listen github webhook")
 	fs.StringVar(&config.GithubUsername, "github-username", "", "GitHub username")
 	fs.StringVar(&config.GithubPassword, "github-password", "", "GitHub password")
+	fs.StringVar(&config.GithubToken, "github-token", "", "GitHub personal access token")
+	fs.Int64Var(&config.GithubAppID, "github-app-id", 0, "GitHub App ID")
+	fs.StringVar(&config.GithubAppPrivateKey, "github-app-private-key", "", "path to GitHub App private key file")
 	fs.StringVar(&config.JiraUsername, "jira-username", "", "JIRA username")
 	fs.StringVar(&config.JiraPassword, "jira-password", "", "JIRA password")
 	fs.StringVar(&config.JiraBaseURL, "jira-baseurl", "", "JIRA endpoint url")
+	fs.StringVar(&config.JiraDeployment, "jira-deployment", jiraDeploymentServer, "JIRA deployment type: server, cloud")
+	fs.StringVar(&config.JiraAPIToken, "jira-api-token", "", "JIRA cloud API token")
+	fs.StringVar(&config.JiraAuth, "jira-auth", jiraAuthBasic, "JIRA authentication mode: basic, token, oauth1")
+	fs.StringVar(&config.JiraToken, "jira-token", "", "JIRA personal access token")
+	fs.StringVar(&config.JiraOAuthConsumerKey, "jira-oauth-consumer-key", "", "JIRA OAuth consumer key")
+	fs.StringVar(&config.JiraOAuthPrivateKey, "jira-oauth-private-key", "", "path to JIRA OAuth private key file")
+	fs.StringVar(&config.JiraOAuthAccessToken, "jira-oauth-access-token", "", "JIRA OAuth access token")
+	fs.StringVar(&config.JiraDocFormat, "jira-doc-format", jiraDocFormatWiki, "JIRA description and comment format: wiki, adf")
 
 	fs.BoolVar(&config.DoPreSync, "do-presync", true, "Do pre-synchronization")
+	fs.StringVar(&config.SyncSchedule, "sync-schedule", "", "interval or cron expression of periodic synchronization while serving, e.g. 30m")
+	fs.StringVar(&config.ShutdownTimeout, "shutdown-timeout", "30s", "how long to drain in-flight webhooks and synchronization on shutdown")
+	fs.IntVar(&config.PresyncWorkers, "presync-workers", 16, "number of issues synchronized in parallel by presync")
+	fs.IntVar(&config.GithubConcurrency, "github-concurrency", 8, "maximum concurrent requests to each GitHub endpoint")
+	fs.IntVar(&config.JiraConcurrency, "jira-concurrency", 8, "maximum concurrent requests to each JIRA target")
+	fs.StringVar(&config.PayloadDir, "payload-dir", "", "directory to store received webhook deliveries for replay")
+	fs.StringVar(&config.DeadLetterDir, "dead-letter-dir", "", "directory to keep failed events, issues and requests for retry, they are only logged if empty")
+	fs.StringVar(&config.AdminToken, "admin-token", "", "bearer token of admin HTTP endpoints, disabled if empty")
+
+	fs.BoolVar(&config.UseSyncCursors, "use-sync-cursors", false, "resume each repo from its sync cursor saved by previous runs")
+	fs.BoolVar(&config.UseLastSyncTimeFile, "use-lastsynctimefile", false, "deprecated, use -use-sync-cursors")
 
-	fs.BoolVar(&config.UseLastSyncTimeFile, "use-lastsynctimefile", false, "Use last sync time file")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "record JIRA changes as planned actions without applying them")
+	fs.StringVar(&config.DryRunFormat, "dry-run-format", dryRunFormatText, "dry-run report format: text, json")
 
 	fs.StringVar(&config.configFile, "config", "./config.toml", "path to config file")
 	fs.StringVar(&config.LogLevel, "L", "debug", "log level: debug, info, warn, error, fatal")
 
-	locChina, _ := time.LoadLocation("Asia/Shanghai")
-	config.Loc = locChina
+	fs.StringVar(&config.Timezone, "timezone", "Asia/Shanghai", "timezone of timestamps in JIRA issues and comments")
+	fs.StringVar(&config.TimestampFormat, "timestamp-format", defaultTimestampFormat, "Go time layout or iso8601 of timestamps in JIRA issues and comments")
+
 	config.GithubIssueSince = time.Now().AddDate(0, -3, 0) // sync since recent 3 months
 
 	return config
@@ -118,25 +308,95 @@ func (config *Config) Parse(args []string) error {
 		return errors.Trace(err)
 	}
 
-	if len(config.FlagSet.Args()) != 0 {
+	if len(config.FlagSet.Args()) != 0 && !config.acceptArgs {
 		return errors.Errorf("'%s' is an invalid flag", config.FlagSet.Arg(0))
 	}
 
-	if config.JiraBaseURL == "" {
-		return errors.New("JIRA base URL should be given")
+	if config.GithubAppID != 0 && config.GithubAppPrivateKey == "" {
+		return errors.New("GitHub App private key should be given")
 	}
 
-	if config.JiraUsername == "" {
-		return errors.New("JIRA username should be given")
+	switch config.DryRunFormat {
+	case dryRunFormatText, dryRunFormatJSON:
+	default:
+		return errors.Errorf("'%s' is an invalid dry-run report format", config.DryRunFormat)
 	}
 
-	if config.JiraPassword == "" {
-		return errors.New("JIRA password should be given")
+	if config.PresyncWorkers <= 0 || config.GithubConcurrency <= 0 || config.JiraConcurrency <= 0 {
+		return errors.New("presync workers, GitHub and JIRA concurrency should be positive")
+	}
+
+	if config.SyncSchedule != "" {
+		if _, err := parseSchedule(config.SyncSchedule); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if config.UseLastSyncTimeFile {
+		logrus.Warnf("use-lastsynctimefile is deprecated, use use-sync-cursors instead. Each repo is resumed from its sync cursor in %s, and %s is only read once when there is no cursor yet",
+			syncCursorFileName, legacyLastSyncTimeFileName)
+		config.UseSyncCursors = true
+	}
+
+	timeout, err := time.ParseDuration(config.ShutdownTimeout)
+	if err != nil || timeout <= 0 {
+		return errors.Errorf("'%s' is an invalid shutdown timeout, use a positive duration e.g. 30s", config.ShutdownTimeout)
+	}
+	config.shutdownTimeout = timeout
+
+	if err := config.checkGithubEndpoints(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := config.checkJiraTargets(); err != nil {
+		return errors.Trace(err)
+	}
+
+	config.Loc, err = time.LoadLocation(config.Timezone)
+	if err != nil {
+		return errors.Annotate(err, "load timezone")
+	}
+
+	if err := config.normalizeRoutes(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := config.normalizeRepoConfigMap(); err != nil {
+		return errors.Trace(err)
+	}
+
+	// credentials could be given as "env:VAR" or "file:/path" reference
+	if err := checkSecrets(config); err != nil {
+		return errors.Annotate(err, "resolve credential")
 	}
 
 	return nil
 }
 
+// formatTime formats timestamps shown in JIRA according to the repo or global
+// timezone and timestamp format
+func (config *Config) formatTime(repoConfig RepoConfig, t time.Time) string {
+	loc := config.Loc
+	if repoConfig.loc != nil {
+		loc = repoConfig.loc
+	}
+
+	format := config.TimestampFormat
+	if repoConfig.TimestampFormat != "" {
+		format = repoConfig.TimestampFormat
+	}
+	if format == timestampFormatISO8601 {
+		format = time.RFC3339
+	}
+
+	return t.In(loc).Format(format)
+}
+
+// String prints config with all credentials redacted, so that config could be logged
+func (config *Config) String() string {
+	return fmt.Sprintf("%+v", redactSecrets(reflect.ValueOf(*config)))
+}
+
 func (config *Config) configFromFile(configFile string) error {
 	_, err := toml.DecodeFile(configFile, config)
 	return errors.Trace(err)
@@ -240,21 +500,3 @@ func getJiraFiledIDs(jiraClient *jira.Client) (map[fieldKey]string, error) {
 
 	return fieldIDs, nil
 }
-
-// return number string with 'customfield_' prefix, e.g. "customfield_10109"
-func (config *Config) getFieldID(key fieldKey) (string, error) {
-	val, ok := config.FieldIDs[key]
-	if !ok {
-		return "", errors.New("fieldKey not exists")
-	}
-	return fmt.Sprintf("customfield_%s", val), nil
-}
-
-// return just number string, e.g. "10109"
-func (config *Config) getFieldKey(key fieldKey) (string, error) {
-	val, ok := config.FieldIDs[key]
-	if !ok {
-		return "", errors.New("fieldKey not exists")
-	}
-	return val, nil
-}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setSecrets sets every string field tagged as secret to a unique value
+// beginning with "plain-secret", and returns the values
+func setSecrets(v reflect.Value, prefix string) []string {
+	var values []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+			value := "plain-secret-" + prefix + field.Name
+			v.Field(i).SetString(value)
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	config := NewConfig()
+	secrets := setSecrets(reflect.ValueOf(config).Elem(), "")
+	config.JiraUsername = "jira-user"
+	config.GithubAppID = 12345
+
+	endpoint := GithubEndpointConfig{BaseURL: "https://github.example.com/api/v3/", AppID: 67890}
+	secrets = append(secrets, setSecrets(reflect.ValueOf(&endpoint).Elem(), "ghe-")...)
+	config.GithubEndpoints = map[string]GithubEndpointConfig{"ghe": endpoint}
+
+	target := JiraTargetConfig{BaseURL: "https://jira.example.com", OAuthConsumerKey: "sync-jira"}
+	secrets = append(secrets, setSecrets(reflect.ValueOf(&target).Elem(), "infra-")...)
+	// references are not sensitive and kept
+	target.Token = "env:INFRA_JIRA_TOKEN"
+	config.JiraTargets = map[string]JiraTargetConfig{"infra": target}
+
+	// fields which must be tagged as secret
+	for _, name := range []string{
+		"GithubPassword", "GithubToken", "GithubAppPrivateKey",
+		"JiraPassword", "JiraAPIToken", "JiraToken", "JiraOAuthPrivateKey", "JiraOAuthAccessToken", "AdminToken",
+		"ghe-Password", "ghe-Token", "ghe-AppPrivateKey",
+		"infra-Password", "infra-APIToken", "infra-OAuthPrivateKey", "infra-OAuthAccessToken",
+	} {
+		if !containsString(secrets, "plain-secret-"+name) {
+			t.Errorf("%s is not tagged as secret", name)
+		}
+	}
+
+	s := config.String()
+	for _, secret := range secrets {
+		if strings.Contains(s, secret) {
+			t.Errorf("Config.String() shows %s", secret)
+		}
+	}
+	for _, want := range []string{redactedSecret, "jira-user", "12345", "67890", "https://jira.example.com", "sync-jira", "env:INFRA_JIRA_TOKEN"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Config.String() does not show %s", want)
+		}
+	}
+}
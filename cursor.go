@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+const syncCursorFileName = "sync_cursors.json"
+
+// legacyLastSyncTimeFileName is the single last sync time of all repos written
+// by older versions, which is only read when there is no cursor file
+const legacyLastSyncTimeFileName = "last_sync_time.txt"
+
+// syncCursorStore keeps per repo the latest updated time of GitHub issues seen
+// by the last successful synchronization of repo, the next synchronization of
+// repo lists issues updated since then. Keys are repo config keys, i.e.
+// "owner/name" or "endpoint:owner/name" in lower case
+type syncCursorStore struct {
+	mu      sync.Mutex
+	path    string
+	cursors map[string]time.Time
+	// repos synchronized by this process, whose cursors are used even if
+	// cursors of previous runs are not
+	synced map[string]bool
+
+	// serializes writes of the file
+	saveMu sync.Mutex
+}
+
+func newSyncCursorStore(path string) *syncCursorStore {
+	return &syncCursorStore{path: path, cursors: map[string]time.Time{}, synced: map[string]bool{}}
+}
+
+// loadSyncCursorStore reads cursors from file, a missing file is an empty store
+func loadSyncCursorStore(path string) (*syncCursorStore, error) {
+	store := newSyncCursorStore(path)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &store.cursors); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return store, nil
+}
+
+func repoCursorKey(repoConfig RepoConfig) string {
+	return repoConfigKey(repoConfig.GithubEndpoint, repoConfig.GithubOwner, repoConfig.name)
+}
+
+func (store *syncCursorStore) get(key string, includePrevious bool) (time.Time, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !includePrevious && !store.synced[key] {
+		return time.Time{}, false
+	}
+	cursor, ok := store.cursors[key]
+	return cursor, ok
+}
+
+func (store *syncCursorStore) set(key string, cursor time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.cursors[key] = cursor
+}
+
+func (store *syncCursorStore) reset(key string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.cursors, key)
+	delete(store.synced, key)
+}
+
+// advance moves cursor of repo synchronized since the given time to the latest
+// updated time seen. Cursor is not moved if issues between it and since were
+// not synchronized, e.g. by `sync -since 1h`
+func (store *syncCursorStore) advance(key string, since, latestUpdated time.Time) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	cursor, ok := store.cursors[key]
+	if ok && since.After(cursor) {
+		return false
+	}
+	store.synced[key] = true
+	if latestUpdated.After(cursor) {
+		store.cursors[key] = latestUpdated
+		return true
+	}
+	return false
+}
+
+// save writes cursors atomically
+func (store *syncCursorStore) save() error {
+	store.saveMu.Lock()
+	defer store.saveMu.Unlock()
+
+	store.mu.Lock()
+	b, err := json.MarshalIndent(store.cursors, "", "  ")
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, b)
+}
+
+// repoSince returns the time since which GitHub issues and comments of repo need
+// to be synchronized. Cursors of previous runs are used with use-sync-cursors,
+// otherwise github-sincetime is used until repo is synchronized once
+func (s *Server) repoSince(repoConfig RepoConfig) time.Time {
+	if cursor, ok := s.cursors.get(repoCursorKey(repoConfig), s.Config.UseSyncCursors); ok {
+		return cursor
+	}
+	return s.Config.GithubIssueSince
+}
+
+// advanceRepoCursor saves the cursor of repo synchronized successfully
+func (s *Server) advanceRepoCursor(l *logrus.Entry, repoConfig RepoConfig, since time.Time, githubIssues []*githubRepoIssue) {
+	var latestUpdated time.Time
+	for _, githubIssue := range githubIssues {
+		if updated := githubIssue.GetUpdatedAt(); updated.After(latestUpdated) {
+			latestUpdated = updated
+		}
+	}
+	if !s.cursors.advance(repoCursorKey(repoConfig), since, latestUpdated) {
+		return
+	}
+	if err := s.cursors.save(); err != nil {
+		l.WithError(err).Warnf("write \"%s\" error", syncCursorFileName)
+	}
+}
+
+// readLegacyLastSyncTime reads the last sync time file of older versions, and
+// synchronizes from the day before it if there is no cursor yet
+func (config *Config) readLegacyLastSyncTime() {
+	if _, err := os.Stat(syncCursorFileName); err == nil {
+		return
+	}
+	b, err := ioutil.ReadFile(legacyLastSyncTimeFileName)
+	if err != nil {
+		return
+	}
+	lastSyncTime, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+	if err != nil {
+		logrus.WithError(err).Warnf("parse \"%s\" error", legacyLastSyncTimeFileName)
+		return
+	}
+	logrus.Infof("no sync cursor yet, synchronize since the day before %v of \"%s\"", lastSyncTime, legacyLastSyncTimeFileName)
+	config.GithubIssueSince = lastSyncTime.AddDate(0, 0, -1)
+}
+
+// runCursor shows, sets or resets sync cursors, e.g.
+// `sync-jira cursor set pingcap/tidb 2018-10-01T00:00:00Z`
+func runCursor(config *Config) int {
+	args := config.FlagSet.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "action should be given: show, set, reset")
+		return exitUsage
+	}
+	store, err := loadSyncCursorStore(syncCursorFileName)
+	if err != nil {
+		logrus.WithError(err).Error("Error reading sync cursors")
+		return exitFailure
+	}
+
+	switch action := args[0]; action {
+	case "show":
+		keys := make([]string, 0, len(store.cursors))
+		for key := range store.cursors {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "REPO\tCURSOR\n")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%s\t%s\n", key, store.cursors[key].Format(time.RFC3339))
+		}
+		tw.Flush()
+		return exitOK
+	case "set":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: cursor set REPO TIME, TIME is RFC3339 time or duration before now")
+			return exitUsage
+		}
+		key, err := cursorKey(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		var since sinceTime
+		if err := since.Set(args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		store.set(key, since.Time)
+	case "reset":
+		// all cursors are reset if no repo is given
+		if len(args) == 1 {
+			store.cursors = map[string]time.Time{}
+		}
+		for _, repo := range args[1:] {
+			key, err := cursorKey(repo)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitUsage
+			}
+			store.reset(key)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown action '%s', use show, set, reset\n", action)
+		return exitUsage
+	}
+
+	if err := store.save(); err != nil {
+		logrus.WithError(err).Error("Error writing sync cursors")
+		return exitFailure
+	}
+	return exitOK
+}
+
+// cursorKey returns the cursor key of repo "owner/name" or "endpoint:owner/name"
+func cursorKey(repo string) (string, error) {
+	endpoint := ""
+	if i := strings.Index(repo, ":"); i >= 0 {
+		endpoint, repo = repo[:i], repo[i+1:]
+	}
+	i := strings.Index(repo, "/")
+	if i < 0 {
+		return "", fmt.Errorf("'%s' is an invalid repo, use owner/name", repo)
+	}
+	return repoConfigKey(endpoint, repo[:i], repo[i+1:]), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncCursorAdvance(t *testing.T) {
+	cursor := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		cursor        time.Time // zero if repo has no cursor
+		since         time.Time
+		latestUpdated time.Time
+		wantMoved     bool
+		wantCursor    time.Time
+		wantSynced    bool
+	}{
+		{"first synchronization", time.Time{}, time.Time{}, cursor, true, cursor, true},
+		{"first synchronization since github-sincetime", time.Time{}, cursor.AddDate(0, 0, -1), cursor, true, cursor, true},
+		{"since the cursor", cursor, cursor, cursor.Add(time.Hour), true, cursor.Add(time.Hour), true},
+		{"since before the cursor", cursor, cursor.Add(-time.Hour), cursor.Add(time.Hour), true, cursor.Add(time.Hour), true},
+		{"gap between cursor and since", cursor, cursor.Add(time.Minute), cursor.Add(time.Hour), false, cursor, false},
+		{"nothing updated", cursor, cursor, time.Time{}, false, cursor, true},
+		{"cursor never moves back", cursor, cursor, cursor.Add(-time.Hour), false, cursor, true},
+	}
+	for _, test := range tests {
+		store := newSyncCursorStore("")
+		if !test.cursor.IsZero() {
+			store.set("pingcap/tidb", test.cursor)
+		}
+		moved := store.advance("pingcap/tidb", test.since, test.latestUpdated)
+		got, _ := store.get("pingcap/tidb", true)
+		if moved != test.wantMoved || !got.Equal(test.wantCursor) {
+			t.Errorf("%s: advance() = %v with cursor %v, want %v with cursor %v", test.name, moved, got, test.wantMoved, test.wantCursor)
+		}
+		if _, synced := store.get("pingcap/tidb", false); synced != test.wantSynced {
+			t.Errorf("%s: cursor used without previous runs = %v, want %v", test.name, synced, test.wantSynced)
+		}
+	}
+}
+
+func TestRunCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync-jira-cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cursors are kept in the working directory
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	cursor := "2018-10-01T00:00:00Z"
+	parsed, _ := time.Parse(time.RFC3339, cursor)
+	tests := []struct {
+		name        string
+		args        []string
+		wantCode    int
+		wantCursors map[string]time.Time
+	}{
+		{"no action", nil, exitUsage, map[string]time.Time{}},
+		{"show without cursors", []string{"show"}, exitOK, map[string]time.Time{}},
+		{"set", []string{"set", "PingCAP/TiDB", cursor}, exitOK, map[string]time.Time{"pingcap/tidb": parsed}},
+		{"set of other endpoint", []string{"set", "ghe:infra/deploy", cursor}, exitOK, map[string]time.Time{"pingcap/tidb": parsed, "ghe:infra/deploy": parsed}},
+		{"set without time", []string{"set", "pingcap/tikv"}, exitUsage, map[string]time.Time{"pingcap/tidb": parsed, "ghe:infra/deploy": parsed}},
+		{"set invalid time", []string{"set", "pingcap/tikv", "yesterday"}, exitUsage, map[string]time.Time{"pingcap/tidb": parsed, "ghe:infra/deploy": parsed}},
+		{"set repo without owner", []string{"set", "tikv", cursor}, exitUsage, map[string]time.Time{"pingcap/tidb": parsed, "ghe:infra/deploy": parsed}},
+		{"show", []string{"show"}, exitOK, map[string]time.Time{"pingcap/tidb": parsed, "ghe:infra/deploy": parsed}},
+		{"reset repo", []string{"reset", "pingcap/tidb"}, exitOK, map[string]time.Time{"ghe:infra/deploy": parsed}},
+		{"unknown action", []string{"move"}, exitUsage, map[string]time.Time{"ghe:infra/deploy": parsed}},
+		{"reset all", []string{"reset"}, exitOK, map[string]time.Time{}},
+	}
+	for _, test := range tests {
+		config := &Config{FlagSet: flag.NewFlagSet("cursor", flag.ContinueOnError)}
+		config.FlagSet.Parse(test.args)
+		if code := runCursor(config); code != test.wantCode {
+			t.Errorf("%s: runCursor() = %d, want %d", test.name, code, test.wantCode)
+		}
+		store, err := loadSyncCursorStore(syncCursorFileName)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(store.cursors, test.wantCursors) {
+			t.Errorf("%s: cursors = %v, want %v", test.name, store.cursors, test.wantCursors)
+		}
+	}
+}
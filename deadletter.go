@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// kinds of dead letters
+const (
+	deadLetterEvent   = "event"   // webhook delivery failed in handler
+	deadLetterIssue   = "issue"   // GitHub issue failed in presync
+	deadLetterRequest = "request" // JIRA or GitHub request failed permanently
+)
+
+var reDeadLetterIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// deadLetter is a failed event or operation kept for inspection and retry
+type deadLetter struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+
+	// webhook delivery of failed event
+	Delivery *webhookDelivery `json:"delivery,omitempty"`
+	// repo "owner/name" or "endpoint:owner/name" and number of failed GitHub issue
+	Repo   string `json:"repo,omitempty"`
+	Number int    `json:"number,omitempty"`
+	// failed request, which is the cause of some failed event or issue
+	Request *failedRequest `json:"request,omitempty"`
+
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first-failed-at"`
+	LastFailedAt  time.Time `json:"last-failed-at"`
+}
+
+// deadLetterStore keeps dead letters as JSON files in dir, failures are only
+// logged if dir is empty. Failures of the same event or GitHub issue share one
+// dead letter, whose attempts are counted
+type deadLetterStore struct {
+	dir string
+	// dead letters are read but never written in dry-run
+	readOnly bool
+	mu       sync.Mutex
+}
+
+func newDeadLetterStore(dir string) *deadLetterStore {
+	return &deadLetterStore{dir: dir}
+}
+
+func (store *deadLetterStore) path(id string) (string, error) {
+	if store.dir == "" {
+		return "", errors.New("dead letters are not stored, dead-letter-dir is empty")
+	}
+	if id == "" || reDeadLetterIDUnsafe.MatchString(id) {
+		return "", fmt.Errorf("'%s' is an invalid dead letter ID", id)
+	}
+	return filepath.Join(store.dir, id+".json"), nil
+}
+
+// fail records a failure of letter, which is merged into the stored one of the same ID
+func (store *deadLetterStore) fail(letter deadLetter, err error) error {
+	if store.dir == "" || store.readOnly {
+		return nil
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	if stored, e := store.get(letter.ID); e == nil {
+		letter.Attempts = stored.Attempts
+		letter.FirstFailedAt = stored.FirstFailedAt
+	} else {
+		letter.FirstFailedAt = now
+	}
+	letter.Attempts++
+	letter.LastFailedAt = now
+	letter.Error = err.Error()
+	return store.write(&letter)
+}
+
+// write saves letter atomically
+func (store *deadLetterStore) write(letter *deadLetter) error {
+	path, err := store.path(letter.ID)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+func (store *deadLetterStore) get(id string) (*deadLetter, error) {
+	path, err := store.path(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(b, &letter); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &letter, nil
+}
+
+// list returns all dead letters in the order of their first failure
+func (store *deadLetterStore) list() ([]*deadLetter, error) {
+	if store.dir == "" {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var letters []*deadLetter
+	for _, match := range matches {
+		letter, err := store.get(strings.TrimSuffix(filepath.Base(match), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].FirstFailedAt.Before(letters[j].FirstFailedAt)
+	})
+	return letters, nil
+}
+
+func (store *deadLetterStore) remove(id string) error {
+	path, err := store.path(id)
+	if err != nil || store.readOnly {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// putFailedEvent keeps webhook delivery failed in handler
+func (store *deadLetterStore) putFailedEvent(delivery webhookDelivery, err error) {
+	letter := deadLetter{
+		ID:       deadLetterEvent + "-" + reDeadLetterIDUnsafe.ReplaceAllString(delivery.GUID, "_"),
+		Kind:     deadLetterEvent,
+		Delivery: &delivery,
+	}
+	if e := store.fail(letter, err); e != nil {
+		logrus.WithError(e).Error("Error storing dead letter of event ", delivery.GUID)
+	}
+}
+
+// putFailedIssue keeps GitHub issue failed in presync
+func (store *deadLetterStore) putFailedIssue(repoConfig RepoConfig, number int, err error) {
+	repo := repoConfigKey(repoConfig.GithubEndpoint, repoConfig.GithubOwner, repoConfig.name)
+	letter := deadLetter{
+		ID:     fmt.Sprintf("%s-%s-%d", deadLetterIssue, reDeadLetterIDUnsafe.ReplaceAllString(repo, "_"), number),
+		Kind:   deadLetterIssue,
+		Repo:   repo,
+		Number: number,
+	}
+	if e := store.fail(letter, err); e != nil {
+		logrus.WithError(e).Errorf("Error storing dead letter of issue %s#%d", repo, number)
+	}
+}
+
+// putFailedRequest logs and keeps request failed permanently
+func (store *deadLetterStore) putFailedRequest(failed failedRequest) {
+	logDeadLetterSink{}.putFailedRequest(failed)
+
+	letter := deadLetter{
+		ID:      fmt.Sprintf("%s-%s", deadLetterRequest, failed.Time.UTC().Format("20060102T150405.000000000")),
+		Kind:    deadLetterRequest,
+		Request: &failed,
+	}
+	err := fmt.Errorf("%s %s: status %d", failed.Method, failed.URL, failed.Status)
+	if failed.Error != "" {
+		err = fmt.Errorf("%s %s: %s", failed.Method, failed.URL, failed.Error)
+	}
+	if e := store.fail(letter, err); e != nil {
+		logrus.WithError(e).Error("Error storing dead letter of request")
+	}
+}
+
+// retryDeadLetter feeds event through demuxEvent again, or synchronizes GitHub
+// issue again the same way as presync, the dead letter is removed on success
+func (s *Server) retryDeadLetter(id string) error {
+	letter, err := s.deadLetters.get(id)
+	if err != nil {
+		return err
+	}
+	l := logrus.WithFields(logrus.Fields{
+		"dead-letter": letter.ID,
+	})
+
+	switch letter.Kind {
+	case deadLetterEvent:
+		d := letter.Delivery
+		err = s.demuxEvent(d.Event, d.GUID, d.Payload, d.Header)
+	case deadLetterIssue:
+		err = s.syncGithubIssueByNumber(l, letter.Repo, letter.Number)
+	default:
+		return fmt.Errorf("%s dead letters could not be retried, retry the failed event or issue instead", letter.Kind)
+	}
+
+	if err != nil {
+		if e := s.deadLetters.fail(*letter, err); e != nil {
+			l.WithError(e).Error("Error storing dead letter")
+		}
+		return err
+	}
+	l.Info("retry dead letter succeeded")
+	return s.deadLetters.remove(letter.ID)
+}
+
+// syncGithubIssueByNumber synchronizes one GitHub issue of repo "owner/name" or
+// "endpoint:owner/name" to JIRA
+func (s *Server) syncGithubIssueByNumber(l *logrus.Entry, repo string, number int) error {
+	endpoint := ""
+	if i := strings.Index(repo, ":"); i >= 0 {
+		endpoint, repo = repo[:i], repo[i+1:]
+	}
+	i := strings.Index(repo, "/")
+	if i < 0 {
+		return fmt.Errorf("'%s' is an invalid repo", repo)
+	}
+	repoConfig, ok := s.Config.getRepoConfig(endpoint, repo[:i], repo[i+1:])
+	if !ok {
+		return fmt.Errorf("repo %s is not configured", repo)
+	}
+
+	githubIssue, err := s.getGithubIssue(repoConfig, number)
+	if err != nil {
+		return err
+	}
+
+	l = l.WithFields(logrus.Fields{
+		"githubIssueURL": githubIssue.GetHTMLURL(),
+	})
+	unlock := s.issueLocks.lock(repoConfig, githubIssue.GetID())
+	defer unlock()
+	commentsSince := s.repoSince(repoConfig)
+	_, err = s.findIssue(repoConfig, githubIssue.GetID())
+	if err != nil && err.Error() == "Issue not exists" {
+		if err := repoConfig.issueFilter().match(githubIssue.Issue, githubIssue.AuthorAssociation); err != nil {
+			l.WithError(err).Info("filter out github issue")
+			return nil
+		}
+		_, err = s.compareSyncIssuesCreate(l, githubIssue.Issue, repoConfig)
+		// all comments of the created issue are synchronized
+		commentsSince = time.Time{}
+	}
+	if err != nil {
+		return err
+	}
+	return s.compareSyncIssue(l, githubIssue.Issue, repoConfig, commentsSince)
+}
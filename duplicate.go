@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// JiraLinkTypeDuplicate represent the JIRA issue link type of duplicates
+const JiraLinkTypeDuplicate = "Duplicate"
+
+// pickCanonicalIssue returns the oldest created JIRA issue as the canonical one
+// of JIRA issues with the same GitHub ID, and the others as duplicates
+func pickCanonicalIssue(jiraIssues []jira.Issue) (jira.Issue, []jira.Issue) {
+	sorted := make([]jira.Issue, len(jiraIssues))
+	copy(sorted, jiraIssues)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, tj := jiraIssueCreated(sorted[i]), jiraIssueCreated(sorted[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		// issues created in the same second are ordered by ID
+		idi, _ := strconv.ParseInt(sorted[i].ID, 10, 64)
+		idj, _ := strconv.ParseInt(sorted[j].ID, 10, 64)
+		return idi < idj
+	})
+	return sorted[0], sorted[1:]
+}
+
+func jiraIssueCreated(jiraIssue jira.Issue) time.Time {
+	if jiraIssue.Fields == nil {
+		return time.Time{}
+	}
+	return time.Time(jiraIssue.Fields.Created)
+}
+
+// mergeDuplicateIssues merges JIRA issues created for the same GitHub issue into
+// the canonical one, which happens when the "opened" webhook races with presync.
+// Comments missing in the canonical issue are moved to it, duplicates are linked
+// to it as "Duplicate" and closed, and their GitHub ID is cleared so that the
+// GitHub issue is only mapped to the canonical issue from now on
+func (s *Server) mergeDuplicateIssues(l *logrus.Entry, repoConfig RepoConfig, canonical jira.Issue, duplicates []jira.Issue) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+
+	// merges of the same issue from concurrent handlers must not move comments twice
+	s.mergeMu.Lock()
+	defer s.mergeMu.Unlock()
+
+	canonicalComments, err := jiraTarget.getIssueComments(canonical.ID)
+	if err != nil {
+		return err
+	}
+	merged := map[string]bool{}
+	for _, comment := range canonicalComments.Comments {
+		merged[commentMergeKey(comment.Body)] = true
+	}
+
+	githubIssueFieldID, err := jiraTarget.getFieldID(gitHubID)
+	if err != nil {
+		return err
+	}
+
+	for _, duplicate := range duplicates {
+		l := l.WithFields(logrus.Fields{
+			"canonical": canonical.Key,
+			"duplicate": duplicate.Key,
+		})
+		reason := "duplicate of " + canonical.Key
+
+		comments, err := jiraTarget.getIssueComments(duplicate.ID)
+		if err != nil {
+			return err
+		}
+		for _, comment := range comments.Comments {
+			key := commentMergeKey(comment.Body)
+			if merged[key] {
+				continue
+			}
+			body := comment.Body
+			// synchronized comments are kept as is to be found by later updates
+			if _, ok := githubCommentID(body); !ok {
+				body = fmt.Sprintf("Moved from %s:\n\n%s", duplicate.Key, body)
+			}
+			_, resp, err := jiraTarget.issues("comment of duplicate issue").AddComment(canonical.ID, &jira.Comment{Body: body})
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			merged[key] = true
+		}
+
+		// duplicate "duplicates" canonical
+		_, err = jiraTarget.issues(reason).AddLink(&jira.IssueLink{
+			Type:         jira.IssueLinkType{Name: JiraLinkTypeDuplicate},
+			InwardIssue:  &jira.Issue{Key: duplicate.Key},
+			OutwardIssue: &jira.Issue{Key: canonical.Key},
+		})
+		if err != nil {
+			l.WithError(err).Warn("link duplicate JIRA issue error")
+		}
+
+		if duplicate.Fields == nil || duplicate.Fields.Status == nil || duplicate.Fields.Status.StatusCategory.Name != JiraStatusDoneName {
+			for _, transitionID := range repoConfig.TransitionMap[JiraTransitionDoneName] {
+				_, err = jiraTarget.handledIssues(reason).DoTransition(duplicate.ID, transitionID)
+				if err != nil {
+					l.WithError(err).Warn("close duplicate JIRA issue error")
+					break
+				}
+			}
+		}
+
+		unmapJiraIssue := jira.Issue{
+			Key: duplicate.Key,
+			Fields: &jira.IssueFields{
+				Unknowns: map[string]interface{}{githubIssueFieldID: nil},
+			},
+		}
+		_, _, err = jiraTarget.issues(reason).Update(&unmapJiraIssue)
+		if err != nil {
+			return err
+		}
+
+		l.Info("merge duplicate JIRA issue")
+	}
+	return nil
+}
+
+// commentMergeKey identifies comments when moving them between duplicates,
+// synchronized comments by GitHub comment ID, others by body
+func commentMergeKey(body string) string {
+	if id, ok := githubCommentID(body); ok {
+		return strconv.FormatInt(id, 10)
+	}
+	return body
+}
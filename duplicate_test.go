@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	logrus "github.com/sirupsen/logrus"
+)
+
+func TestPickCanonicalIssue(t *testing.T) {
+	issue := func(id, created, status string) jira.Issue {
+		ret := jira.Issue{ID: id, Key: "TEST-" + id, Fields: &jira.IssueFields{
+			Status: &jira.Status{StatusCategory: jira.StatusCategory{Name: status}},
+		}}
+		if created != "" {
+			t, _ := time.Parse(time.RFC3339, created)
+			ret.Fields.Created = jira.Time(t)
+		}
+		return ret
+	}
+	tests := []struct {
+		name           string
+		issues         []jira.Issue
+		wantCanonical  string
+		wantDuplicates []string
+	}{
+		{
+			name:          "single issue",
+			issues:        []jira.Issue{issue("1", "2018-10-01T00:00:00Z", "To Do")},
+			wantCanonical: "1",
+		},
+		{
+			name: "oldest created",
+			issues: []jira.Issue{
+				issue("1", "2018-10-01T00:00:02Z", "To Do"),
+				issue("2", "2018-10-01T00:00:01Z", "To Do"),
+				issue("3", "2018-10-01T00:00:03Z", "To Do"),
+			},
+			wantCanonical:  "2",
+			wantDuplicates: []string{"1", "3"},
+		},
+		{
+			name: "created in the same second ordered by numeric ID",
+			issues: []jira.Issue{
+				issue("10", "2018-10-01T00:00:00Z", "To Do"),
+				issue("9", "2018-10-01T00:00:00Z", "To Do"),
+				issue("100", "2018-10-01T00:00:00Z", "To Do"),
+			},
+			wantCanonical:  "9",
+			wantDuplicates: []string{"10", "100"},
+		},
+		{
+			// closing the canonical issue is not undone by merging duplicates
+			name: "done issue created first is canonical",
+			issues: []jira.Issue{
+				issue("2", "2018-10-01T00:00:01Z", "To Do"),
+				issue("1", "2018-10-01T00:00:00Z", JiraStatusDoneName),
+			},
+			wantCanonical:  "1",
+			wantDuplicates: []string{"2"},
+		},
+		{
+			name: "done and not done created in the same second",
+			issues: []jira.Issue{
+				issue("2", "2018-10-01T00:00:00Z", "To Do"),
+				issue("3", "2018-10-01T00:00:00Z", JiraStatusDoneName),
+				issue("1", "2018-10-01T00:00:00Z", JiraStatusDoneName),
+			},
+			wantCanonical:  "1",
+			wantDuplicates: []string{"2", "3"},
+		},
+		{
+			name: "issue without created time",
+			issues: []jira.Issue{
+				issue("1", "2018-10-01T00:00:00Z", "To Do"),
+				{ID: "2", Key: "TEST-2"},
+			},
+			wantCanonical:  "2",
+			wantDuplicates: []string{"1"},
+		},
+	}
+	for _, test := range tests {
+		canonical, duplicates := pickCanonicalIssue(test.issues)
+		var ids []string
+		for _, duplicate := range duplicates {
+			ids = append(ids, duplicate.ID)
+		}
+		if canonical.ID != test.wantCanonical || !reflect.DeepEqual(ids, test.wantDuplicates) {
+			t.Errorf("%s: pickCanonicalIssue() = %s, %v, want %s, %v", test.name, canonical.ID, ids, test.wantCanonical, test.wantDuplicates)
+		}
+	}
+}
+
+func TestMergeDuplicateIssues(t *testing.T) {
+	synced := func(id string) *jira.Comment {
+		return &jira.Comment{Body: "body\n{anchor:github-comment-" + id + "}"}
+	}
+	note := &jira.Comment{Body: "note written in JIRA"}
+	comments := map[string][]*jira.Comment{
+		"1": {synced("100")},
+		"2": {synced("100"), synced("200"), note},
+		"3": {synced("200"), note},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// rest/api/2/issue/{id}/comment
+		id := strings.Split(r.URL.Path, "/")[5]
+		json.NewEncoder(w).Encode(jiraCommentsPage{Comments: comments[id], Total: len(comments[id])})
+	}))
+	defer server.Close()
+
+	jiraClient, err := jira.NewClient(nil, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeJiraIssueAPI{}
+	s := &Server{jiraTargets: map[string]*jiraTarget{"": {
+		client:              jiraClient,
+		issueService:        fake,
+		handledIssueService: fake,
+		fieldIDs:            map[fieldKey]string{gitHubID: "10000"},
+	}}}
+	repoConfig := RepoConfig{TransitionMap: map[string][]string{JiraTransitionDoneName: {"31", "41"}}}
+
+	status := func(name string) *jira.IssueFields {
+		return &jira.IssueFields{Status: &jira.Status{StatusCategory: jira.StatusCategory{Name: name}}}
+	}
+	canonical := jira.Issue{ID: "1", Key: "TEST-1"}
+	duplicates := []jira.Issue{
+		{ID: "2", Key: "TEST-2", Fields: status("To Do")},
+		{ID: "3", Key: "TEST-3", Fields: status(JiraStatusDoneName)},
+	}
+	if err := s.mergeDuplicateIssues(logrus.WithField("test", "merge"), repoConfig, canonical, duplicates); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		// synchronized comments are moved as is, once
+		`AddComment 1 "body\n{anchor:github-comment-200}"`,
+		`AddComment 1 "Moved from TEST-2:\n\nnote written in JIRA"`,
+		"AddLink TEST-2 Duplicate TEST-1",
+		// only duplicates not done yet are closed
+		"DoTransition 2 31",
+		"DoTransition 2 41",
+		"Update TEST-2 map[customfield_10000:<nil>]",
+		"AddLink TEST-3 Duplicate TEST-1",
+		"Update TEST-3 map[customfield_10000:<nil>]",
+	}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("mergeDuplicateIssues() made\n%s\nwant\n%s", strings.Join(fake.calls, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	githubGoogle "github.com/google/go-github/github"
+)
+
+// issueFilter is the compiled IssueFilterConfig of a repo
+type issueFilter struct {
+	IssueFilterConfig
+
+	includeTitles []*regexp.Regexp
+	excludeTitles []*regexp.Regexp
+}
+
+var defaultIssueFilter = &issueFilter{}
+
+func newIssueFilter(filterConfig IssueFilterConfig) (*issueFilter, error) {
+	f := &issueFilter{IssueFilterConfig: filterConfig}
+	for _, pattern := range filterConfig.IncludeTitlePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.includeTitles = append(f.includeTitles, re)
+	}
+	for _, pattern := range filterConfig.ExcludeTitlePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.excludeTitles = append(f.excludeTitles, re)
+	}
+	return f, nil
+}
+
+// match returns nil if GitHub issue should be synchronized, otherwise the error
+// tells why it is filtered out. Author association is empty when unknown, which
+// matches neither include nor exclude associations
+func (f *issueFilter) match(issue githubGoogle.Issue, authorAssociation string) error {
+	var labels []string
+	for _, label := range issue.Labels {
+		labels = append(labels, label.GetName())
+	}
+	author := issue.GetUser().GetLogin()
+	title := issue.GetTitle()
+
+	if len(f.IncludeLabels) != 0 && !containsAnyFold(f.IncludeLabels, labels...) {
+		return fmt.Errorf("issue has none of labels %v", f.IncludeLabels)
+	}
+	if containsAnyFold(f.ExcludeLabels, labels...) {
+		return fmt.Errorf("issue has one of excluded labels %v", f.ExcludeLabels)
+	}
+
+	if len(f.IncludeAuthors) != 0 && !containsAnyFold(f.IncludeAuthors, author) {
+		return fmt.Errorf("author %s is not included", author)
+	}
+	if containsAnyFold(f.ExcludeAuthors, author) {
+		return fmt.Errorf("author %s is excluded", author)
+	}
+	if f.ExcludeBots && isGithubBot(issue.GetUser()) {
+		return fmt.Errorf("author %s is a bot", author)
+	}
+
+	if len(f.includeTitles) != 0 && !matchAny(f.includeTitles, title) {
+		return fmt.Errorf("title matches none of patterns %v", f.IncludeTitlePatterns)
+	}
+	if matchAny(f.excludeTitles, title) {
+		return fmt.Errorf("title matches one of excluded patterns %v", f.ExcludeTitlePatterns)
+	}
+
+	if len(f.IncludeAssociations) != 0 && !containsAnyFold(f.IncludeAssociations, authorAssociation) {
+		return fmt.Errorf("author association '%s' is not included", authorAssociation)
+	}
+	if authorAssociation != "" && containsAnyFold(f.ExcludeAssociations, authorAssociation) {
+		return fmt.Errorf("author association '%s' is excluded", authorAssociation)
+	}
+
+	return nil
+}
+
+// isGithubBot reports whether GitHub user is a bot, GitHub App bots have the
+// type "Bot" and the login suffix "[bot]"
+func isGithubBot(user *githubGoogle.User) bool {
+	return user.GetType() == "Bot" || strings.HasSuffix(user.GetLogin(), "[bot]")
+}
+
+// containsAnyFold reports whether any of values is in list, case insensitively
+func containsAnyFold(list []string, values ...string) bool {
+	for _, v := range values {
+		for _, item := range list {
+			if v != "" && strings.EqualFold(item, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	githubGoogle "github.com/google/go-github/github"
+)
+
+func TestIssueFilterMatch(t *testing.T) {
+	issue := func(author, userType, title string, labels ...string) githubGoogle.Issue {
+		ret := githubGoogle.Issue{
+			Title: githubGoogle.String(title),
+			User:  &githubGoogle.User{Login: githubGoogle.String(author), Type: githubGoogle.String(userType)},
+		}
+		for _, label := range labels {
+			ret.Labels = append(ret.Labels, githubGoogle.Label{Name: githubGoogle.String(label)})
+		}
+		return ret
+	}
+	bug := issue("alice", "User", "panic in planner", "type/bug", "sig/planner")
+
+	tests := []struct {
+		name        string
+		filter      IssueFilterConfig
+		issue       githubGoogle.Issue
+		association string
+		want        bool
+	}{
+		{"empty filter", IssueFilterConfig{}, bug, "", true},
+		{"included label", IssueFilterConfig{IncludeLabels: []string{"type/bug"}}, bug, "", true},
+		{"included label case insensitive", IssueFilterConfig{IncludeLabels: []string{"Type/Bug"}}, bug, "", true},
+		{"none of included labels", IssueFilterConfig{IncludeLabels: []string{"type/feature"}}, bug, "", false},
+		{"no labels with included labels", IssueFilterConfig{IncludeLabels: []string{"type/bug"}}, issue("alice", "User", "t"), "", false},
+		{"excluded label", IssueFilterConfig{ExcludeLabels: []string{"sig/planner"}}, bug, "", false},
+		{"excluded label wins over included", IssueFilterConfig{IncludeLabels: []string{"type/bug"}, ExcludeLabels: []string{"SIG/planner"}}, bug, "", false},
+		{"included author", IssueFilterConfig{IncludeAuthors: []string{"Alice"}}, bug, "", true},
+		{"author not included", IssueFilterConfig{IncludeAuthors: []string{"bob"}}, bug, "", false},
+		{"excluded author", IssueFilterConfig{ExcludeAuthors: []string{"alice"}}, bug, "", false},
+		{"bot by type", IssueFilterConfig{ExcludeBots: true}, issue("renovate", "Bot", "t"), "", false},
+		{"bot by login", IssueFilterConfig{ExcludeBots: true}, issue("dependabot[bot]", "User", "t"), "", false},
+		{"bots kept without exclude-bots", IssueFilterConfig{}, issue("renovate", "Bot", "t"), "", true},
+		{"user with exclude-bots", IssueFilterConfig{ExcludeBots: true}, bug, "", true},
+		{"included title", IssueFilterConfig{IncludeTitlePatterns: []string{"^panic"}}, bug, "", true},
+		{"title matches none", IssueFilterConfig{IncludeTitlePatterns: []string{"^\\[RFC\\]", "flaky"}}, bug, "", false},
+		{"excluded title", IssueFilterConfig{ExcludeTitlePatterns: []string{"(?i)PLANNER"}}, bug, "", false},
+		{"included association", IssueFilterConfig{IncludeAssociations: []string{"MEMBER", "OWNER"}}, bug, "member", true},
+		{"association not included", IssueFilterConfig{IncludeAssociations: []string{"MEMBER"}}, bug, "NONE", false},
+		{"unknown association not included", IssueFilterConfig{IncludeAssociations: []string{"MEMBER"}}, bug, "", false},
+		{"excluded association", IssueFilterConfig{ExcludeAssociations: []string{"FIRST_TIMER"}}, bug, "FIRST_TIMER", false},
+		{"unknown association not excluded", IssueFilterConfig{ExcludeAssociations: []string{"NONE"}}, bug, "", true},
+		{
+			name: "all conditions",
+			filter: IssueFilterConfig{
+				IncludeLabels:        []string{"type/bug"},
+				ExcludeLabels:        []string{"wontfix"},
+				IncludeAuthors:       []string{"alice"},
+				ExcludeBots:          true,
+				IncludeTitlePatterns: []string{"panic"},
+				ExcludeTitlePatterns: []string{"^\\[WIP\\]"},
+				IncludeAssociations:  []string{"CONTRIBUTOR"},
+			},
+			issue:       bug,
+			association: "CONTRIBUTOR",
+			want:        true,
+		},
+	}
+	for _, test := range tests {
+		f, err := newIssueFilter(test.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err = f.match(test.issue, test.association)
+		if got := err == nil; got != test.want {
+			t.Errorf("%s: match() = %v, want matched %v", test.name, err, test.want)
+		}
+	}
+
+	if _, err := newIssueFilter(IssueFilterConfig{ExcludeTitlePatterns: []string{"("}}); err == nil {
+		t.Error("newIssueFilter() accepts invalid title pattern")
+	}
+}
@@ -5,13 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"strconv"
+	"time"
 
 	githubGoogle "github.com/google/go-github/github"
 	logrus "github.com/sirupsen/logrus"
 )
 
-const commentDateFormat = "15:04 PM, January 2 2006"
+const defaultTimestampFormat = "3:04 PM, January 2 2006"
+
+// timestampFormatISO8601 could be used as timestamp format for ISO 8601 timestamps
+const timestampFormatISO8601 = "iso8601"
 
 type githubIssueOptions struct {
 	githubIssueNumber        string
@@ -22,9 +27,10 @@ type githubIssueOptions struct {
 	githubIssueTime          string
 	githubIssueAssigneeLogin string
 	githubLabels             []githubGoogle.Label
+	githubMilestone          string
 }
 
-func (s *Server) extractGithubIssueOptions(githubIssue githubGoogle.Issue) githubIssueOptions {
+func (s *Server) extractGithubIssueOptions(githubIssue githubGoogle.Issue, repoConfig RepoConfig) githubIssueOptions {
 
 	options := githubIssueOptions{
 		githubIssueNumber:        strconv.Itoa(githubIssue.GetNumber()),
@@ -32,9 +38,10 @@ func (s *Server) extractGithubIssueOptions(githubIssue githubGoogle.Issue) githu
 		githubIssueUserLogin:     githubIssue.GetUser().GetLogin(),
 		githubIssueUserLink:      githubIssue.GetUser().GetHTMLURL(),
 		githubIssueUserName:      githubIssue.GetUser().GetName(),
-		githubIssueTime:          githubIssue.GetCreatedAt().In(s.Config.Loc).Format(commentDateFormat),
+		githubIssueTime:          s.Config.formatTime(repoConfig, githubIssue.GetCreatedAt()),
 		githubIssueAssigneeLogin: githubIssue.GetAssignee().GetLogin(),
 		githubLabels:             githubIssue.Labels,
+		githubMilestone:          githubIssue.GetMilestone().GetTitle(),
 	}
 
 	return options
@@ -49,7 +56,7 @@ type githubIssueCommentOptions struct {
 	githubIssueCommentTime      string
 }
 
-func (s *Server) extractGithubIssueCommentOptions(githubIssueComment githubGoogle.IssueComment) githubIssueCommentOptions {
+func (s *Server) extractGithubIssueCommentOptions(githubIssueComment githubGoogle.IssueComment, repoConfig RepoConfig) githubIssueCommentOptions {
 
 	options := githubIssueCommentOptions{
 		githubIssueCommentID:        strconv.FormatInt(githubIssueComment.GetID(), 10),
@@ -57,28 +64,72 @@ func (s *Server) extractGithubIssueCommentOptions(githubIssueComment githubGoogl
 		githubIssueCommentUserLogin: githubIssueComment.GetUser().GetLogin(),
 		githubIssueCommentUserLink:  githubIssueComment.GetUser().GetHTMLURL(),
 		githubIssueCommentUserName:  githubIssueComment.GetUser().GetName(),
-		githubIssueCommentTime:      githubIssueComment.GetCreatedAt().In(s.Config.Loc).Format(commentDateFormat),
+		githubIssueCommentTime:      s.Config.formatTime(repoConfig, githubIssueComment.GetCreatedAt()),
 	}
 
 	return options
 }
 
-func (s *Server) getGithubIssuesByRepo(l *logrus.Entry, owner, repoName string) ([]*githubGoogle.Issue, error) {
-	var allIssues []*githubGoogle.Issue
+// githubRepoIssue is GitHub issue listed by repo, with author association
+// which go-github Issue lacks
+type githubRepoIssue struct {
+	githubGoogle.Issue
+	AuthorAssociation string `json:"author_association,omitempty"`
+}
+
+// getGithubIssue gets GitHub issue of repo by number with author association
+func (s *Server) getGithubIssue(repoConfig RepoConfig, number int) (*githubRepoIssue, error) {
+	githubClient := s.githubClientOf(repoConfig.GithubEndpoint)
+	req, err := githubClient.NewRequest("GET", fmt.Sprintf("repos/%s/%s/issues/%d", repoConfig.GithubOwner, repoConfig.name, number), nil)
+	if err != nil {
+		return nil, err
+	}
+	githubIssue := new(githubRepoIssue)
+	resp, err := githubClient.Do(context.Background(), req, githubIssue)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return githubIssue, nil
+}
+
+// githubIssueAuthorAssociation gets author association of issue from webhook payload
+func githubIssueAuthorAssociation(payload []byte) string {
+	var event struct {
+		Issue struct {
+			AuthorAssociation string `json:"author_association"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return ""
+	}
+	return event.Issue.AuthorAssociation
+}
+
+func (s *Server) getGithubIssuesByRepo(l *logrus.Entry, repoConfig RepoConfig, since time.Time) ([]*githubRepoIssue, error) {
+	var allIssues []*githubRepoIssue
+	githubClient := s.githubClientOf(repoConfig.GithubEndpoint)
 	ctx := context.Background()
-	githubIssueListByRepoOptions := &githubGoogle.IssueListByRepoOptions{
-		Since:     s.Config.GithubIssueSince,
-		State:     "all",
-		Sort:      "created",
-		Direction: "asc",
-		ListOptions: githubGoogle.ListOptions{
-			Page:    1,
-			PerPage: 100, // maxmium is 100
-		},
+	// same as IssueListByRepoOptions, issues are decoded by ourselves to keep author association
+	query := url.Values{
+		"state":     {"all"},
+		"sort":      {"created"},
+		"direction": {"asc"},
+		"per_page":  {"100"}, // maxmium is 100
+	}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339))
 	}
+	page := 1
 	for {
-		l.Debugf("get github issues by repo per page %4d in %s", githubIssueListByRepoOptions.ListOptions.Page, repoName)
-		issues, resp, err := s.githubClient.Issues.ListByRepo(ctx, owner, repoName, githubIssueListByRepoOptions)
+		l.Debugf("get github issues by repo per page %4d in %s", page, repoConfig.fullName())
+		query.Set("page", strconv.Itoa(page))
+		req, err := githubClient.NewRequest("GET", fmt.Sprintf("repos/%s/%s/issues?%s", repoConfig.GithubOwner, repoConfig.name, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		var issues []*githubRepoIssue
+		resp, err := githubClient.Do(ctx, req, &issues)
 
 		if err != nil {
 			return nil, err
@@ -95,7 +146,7 @@ func (s *Server) getGithubIssuesByRepo(l *logrus.Entry, owner, repoName string)
 
 			break
 		}
-		githubIssueListByRepoOptions.Page = resp.NextPage
+		page = resp.NextPage
 
 		resp.Body.Close()
 
@@ -104,6 +155,41 @@ func (s *Server) getGithubIssuesByRepo(l *logrus.Entry, owner, repoName string)
 	return allIssues, nil
 }
 
+// getGithubReposByOrg lists repos of organization on GitHub endpoint, archived repos are skipped
+func (s *Server) getGithubReposByOrg(l *logrus.Entry, endpoint, org string) ([]*githubGoogle.Repository, error) {
+	var allRepos []*githubGoogle.Repository
+	ctx := context.Background()
+	githubRepoListByOrgOptions := &githubGoogle.RepositoryListByOrgOptions{
+		ListOptions: githubGoogle.ListOptions{
+			Page:    1,
+			PerPage: 100, // maxmium is 100
+		},
+	}
+	for {
+		l.Debugf("get github repos by org per page %4d in %s", githubRepoListByOrgOptions.ListOptions.Page, org)
+		repos, resp, err := s.githubClientOf(endpoint).Repositories.ListByOrg(ctx, org, githubRepoListByOrgOptions)
+
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			if !repo.GetArchived() {
+				allRepos = append(allRepos, repo)
+			}
+		}
+		if resp.NextPage == 0 {
+			resp.Body.Close()
+			break
+		}
+		githubRepoListByOrgOptions.Page = resp.NextPage
+
+		resp.Body.Close()
+
+	}
+
+	return allRepos, nil
+}
+
 func dumpGithubIssue(allIssues []*githubGoogle.Issue, owner, repoName string) error {
 	b, err := json.Marshal(allIssues)
 	if err != nil {
@@ -117,11 +203,12 @@ func dumpGithubIssue(allIssues []*githubGoogle.Issue, owner, repoName string) er
 	return nil
 }
 
-func (s *Server) getGithubIssueComments(owner, repoName string, number int) ([]*githubGoogle.IssueComment, error) {
+// getGithubIssueComments lists comments of issue updated since the given time, all comments if zero
+func (s *Server) getGithubIssueComments(repoConfig RepoConfig, number int, since time.Time) ([]*githubGoogle.IssueComment, error) {
 	var allComments []*githubGoogle.IssueComment
 	ctx := context.Background()
 	githubIssueListCommentsOptions := &githubGoogle.IssueListCommentsOptions{
-		Since:     s.Config.GithubIssueSince,
+		Since:     since,
 		Sort:      "created",
 		Direction: "asc",
 		ListOptions: githubGoogle.ListOptions{
@@ -130,7 +217,7 @@ func (s *Server) getGithubIssueComments(owner, repoName string, number int) ([]*
 		},
 	}
 	for {
-		comments, resp, err := s.githubClient.Issues.ListComments(ctx, owner, repoName, number, githubIssueListCommentsOptions)
+		comments, resp, err := s.githubClientOf(repoConfig.GithubEndpoint).Issues.ListComments(ctx, repoConfig.GithubOwner, repoConfig.name, number, githubIssueListCommentsOptions)
 
 		if err != nil {
 			return nil, err
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const githubAPIURL = "https://api.github.com/"
+
+// installation tokens live one hour, refresh them a bit earlier
+const githubTokenRefreshMargin = 5 * time.Minute
+
+// newGithubHTTPClient returns the http client used by GitHub API client according
+// to the authentication of endpoint, GitHub App is preferred, then personal access
+// token, then username/password, and no authentication at last
+func newGithubHTTPClient(endpoint GithubEndpointConfig) (*http.Client, error) {
+	switch {
+	case endpoint.AppID != 0:
+		transport, err := newGithubAppTransport(endpoint.BaseURL, endpoint.AppID, endpoint.AppPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: transport}, nil
+	case endpoint.Token != "":
+		return &http.Client{Transport: &githubTokenTransport{token: newSecret(endpoint.Token)}}, nil
+	case endpoint.Username != "":
+		transport := &basicAuthTransport{
+			username: endpoint.Username,
+			password: newSecret(endpoint.Password),
+		}
+		return &http.Client{Transport: transport}, nil
+	default:
+		return http.DefaultClient, nil
+	}
+}
+
+// githubTokenTransport authenticates requests with a personal access token
+type githubTokenTransport struct {
+	token     *secret
+	transport http.RoundTripper
+}
+
+func (t *githubTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token.Value()
+	if err != nil {
+		return nil, err
+	}
+	req2 := cloneRequest(req)
+	req2.Header.Set("Authorization", "token "+token)
+	return transportOrDefault(t.transport).RoundTrip(req2)
+}
+
+// githubInstallationToken is an installation access token of GitHub App
+type githubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// githubAppTransport authenticates requests as GitHub App installation, the
+// installation is chosen by the owner in request path, and installation tokens
+// are minted and refreshed automatically per owner
+type githubAppTransport struct {
+	baseURL    string
+	appID      int64
+	privateKey *rsaKeySecret
+	transport  http.RoundTripper
+
+	mu     sync.Mutex
+	tokens map[string]*githubInstallationToken
+	// tokens being minted by owner, concurrent requests of the owner wait for
+	// the same one, and requests of other owners are not blocked
+	minting map[string]*githubTokenCall
+}
+
+// githubTokenCall is an installation token being minted
+type githubTokenCall struct {
+	done  chan struct{}
+	token *githubInstallationToken
+	err   error
+}
+
+func newGithubAppTransport(baseURL string, appID int64, privateKeyRef string) (*githubAppTransport, error) {
+	privateKey, err := newRSAKeySecret(privateKeyRef)
+	if err != nil {
+		return nil, err
+	}
+
+	return &githubAppTransport{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/",
+		appID:      appID,
+		privateKey: privateKey,
+		tokens:     map[string]*githubInstallationToken{},
+		minting:    map[string]*githubTokenCall{},
+	}, nil
+}
+
+func (t *githubAppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	owner := githubOwnerFromPath(req.URL.Path)
+	if owner == "" {
+		return transportOrDefault(t.transport).RoundTrip(req)
+	}
+
+	token, err := t.installationToken(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	req2 := cloneRequest(req)
+	req2.Header.Set("Authorization", "token "+token)
+	resp, err := transportOrDefault(t.transport).RoundTrip(req2)
+	// token revoked, e.g. app reinstalled, is minted again by the next request
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.evictToken(owner, token)
+	}
+	return resp, err
+}
+
+// installationToken returns a valid installation token of owner, mint a new one
+// if expired. The lock is only held to access the cache, not while minting
+func (t *githubAppTransport) installationToken(owner string) (string, error) {
+	t.mu.Lock()
+	if token, ok := t.tokens[owner]; ok && time.Until(token.ExpiresAt) > githubTokenRefreshMargin {
+		t.mu.Unlock()
+		return token.Token, nil
+	}
+	if call, ok := t.minting[owner]; ok {
+		t.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return "", call.err
+		}
+		return call.token.Token, nil
+	}
+	call := &githubTokenCall{done: make(chan struct{})}
+	t.minting[owner] = call
+	t.mu.Unlock()
+
+	call.token, call.err = t.mintInstallationToken(owner)
+
+	t.mu.Lock()
+	if call.err == nil {
+		t.tokens[owner] = call.token
+	}
+	delete(t.minting, owner)
+	t.mu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return "", call.err
+	}
+	return call.token.Token, nil
+}
+
+// mintInstallationToken creates a new installation token of owner
+func (t *githubAppTransport) mintInstallationToken(owner string) (*githubInstallationToken, error) {
+	installationID, err := t.installationID(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	token := new(githubInstallationToken)
+	apiEndpoint := fmt.Sprintf("app/installations/%d/access_tokens", installationID)
+	if err := t.doAsApp("POST", apiEndpoint, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// evictToken removes the cached token of owner unless it is already replaced
+func (t *githubAppTransport) evictToken(owner, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cached, ok := t.tokens[owner]; ok && cached.Token == token {
+		delete(t.tokens, owner)
+	}
+}
+
+// installationID finds the installation of the app on an organization or a user
+func (t *githubAppTransport) installationID(owner string) (int64, error) {
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	err := t.doAsApp("GET", fmt.Sprintf("orgs/%s/installation", owner), &installation)
+	if err != nil {
+		err = t.doAsApp("GET", fmt.Sprintf("users/%s/installation", owner), &installation)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("GitHub App is not installed for %s: %v", owner, err)
+	}
+	return installation.ID, nil
+}
+
+// doAsApp sends request authenticated by the app JWT
+func (t *githubAppTransport) doAsApp(method, apiEndpoint string, v interface{}) error {
+	jwt, err := t.appJWT()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, t.baseURL+apiEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+
+	resp, err := transportOrDefault(t.transport).RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", method, apiEndpoint, resp.Status, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// appJWT signs a JWT with RS256 to authenticate as the app, valid for less than 10 minutes
+func (t *githubAppTransport) appJWT() (string, error) {
+	privateKey, err := t.privateKey.Key()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // allow clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": t.appID,
+	})
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+// githubOwnerFromPath gets owner from API path like /repos/:owner/..., /orgs/:owner/...
+func githubOwnerFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	// GitHub Enterprise API is served under /api/v3/
+	if len(parts) > 2 && parts[0] == "api" && parts[1] == "v3" {
+		parts = parts[2:]
+	}
+	if len(parts) < 2 {
+		return ""
+	}
+	switch parts[0] {
+	case "repos", "orgs", "users":
+		return parts[1]
+	}
+	return ""
+}
+
+// parseRSAPrivateKey parses PEM encoded PKCS1 or PKCS8 RSA private key
+func parseRSAPrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA key")
+	}
+	return rsaKey, nil
+}
+
+// cloneRequest returns a clone of request with deep copied header, as
+// RoundTripper should not modify the request
+func cloneRequest(req *http.Request) *http.Request {
+	req2 := new(http.Request)
+	*req2 = *req
+	req2.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		req2.Header[k] = append([]string(nil), v...)
+	}
+	return req2
+}
+
+func transportOrDefault(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		return http.DefaultTransport
+	}
+	return transport
+}
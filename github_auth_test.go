@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGithubApp serves installations of owners and mints installation tokens
+// "owner-N", tokens in revoked are rejected with 401
+type fakeGithubApp struct {
+	mu      sync.Mutex
+	minted  map[string]int
+	revoked map[string]bool
+	// minting tokens of owner blocks until the channel is closed
+	block map[string]chan struct{}
+}
+
+func (app *fakeGithubApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "orgs" && parts[2] == "installation":
+		// installation ID is the length of the owner name
+		fmt.Fprintf(w, `{"id":%d}`, len(parts[1]))
+	case len(parts) == 4 && parts[0] == "app" && parts[3] == "access_tokens":
+		owner := map[string]string{"4": "slow", "7": "pingcap"}[parts[2]]
+		app.mu.Lock()
+		block := app.block[owner]
+		app.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		app.mu.Lock()
+		app.minted[owner]++
+		token := fmt.Sprintf("%s-%d", owner, app.minted[owner])
+		app.mu.Unlock()
+		fmt.Fprintf(w, `{"token":"%s","expires_at":"%s"}`, token, time.Now().Add(time.Hour).Format(time.RFC3339))
+	case len(parts) >= 2 && parts[0] == "repos":
+		app.mu.Lock()
+		revoked := app.revoked[strings.TrimPrefix(r.Header.Get("Authorization"), "token ")]
+		app.mu.Unlock()
+		if revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (app *fakeGithubApp) mintedTokens(owner string) int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.minted[owner]
+}
+
+func TestGithubAppTransport(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	app := &fakeGithubApp{
+		minted:  map[string]int{},
+		revoked: map[string]bool{},
+		block:   map[string]chan struct{}{"slow": make(chan struct{})},
+	}
+	server := httptest.NewServer(app)
+	defer server.Close()
+	transport := &githubAppTransport{
+		baseURL:    server.URL + "/",
+		appID:      1,
+		privateKey: &rsaKeySecret{secret: newSecret(string(keyPEM))},
+		tokens:     map[string]*githubInstallationToken{},
+		minting:    map[string]*githubTokenCall{},
+	}
+	client := &http.Client{Transport: transport}
+	get := func(owner string) int {
+		resp, err := client.Get(server.URL + "/repos/" + owner + "/repo")
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// minting token of one owner blocks neither other owners nor the cache
+	slowDone := make(chan struct{})
+	go func() {
+		get("slow")
+		close(slowDone)
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("pingcap")
+		}()
+	}
+	wg.Wait()
+	if minted := app.mintedTokens("pingcap"); minted != 1 {
+		t.Errorf("%d tokens minted for concurrent requests of the same owner, want 1", minted)
+	}
+	select {
+	case <-slowDone:
+		t.Error("request of slow owner finished before its token is minted")
+	default:
+	}
+	close(app.block["slow"])
+	<-slowDone
+	if minted := app.mintedTokens("slow"); minted != 1 {
+		t.Errorf("%d tokens minted for slow owner, want 1", minted)
+	}
+
+	// revoked token is minted again by the next request
+	app.mu.Lock()
+	app.revoked["pingcap-1"] = true
+	app.mu.Unlock()
+	if status := get("pingcap"); status != http.StatusUnauthorized {
+		t.Errorf("status of revoked token = %d, want 401", status)
+	}
+	if status := get("pingcap"); status != http.StatusOK {
+		t.Errorf("status after revoked token = %d, want 200", status)
+	}
+	if minted := app.mintedTokens("pingcap"); minted != 2 {
+		t.Errorf("%d tokens minted after revoked token, want 2", minted)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	githubGoogle "github.com/google/go-github/github"
+	"github.com/juju/errors"
+)
+
+// githubDotComHost is the webhook host of the default GitHub endpoint
+const githubDotComHost = "github.com"
+
+// checkGithubEndpoints validates GitHub endpoints, the empty name is reserved
+// for github.com
+func (config *Config) checkGithubEndpoints() error {
+	for name, endpoint := range config.GithubEndpoints {
+		if name == "" {
+			return errors.New("GitHub endpoint name should be given")
+		}
+		if endpoint.BaseURL == "" {
+			return errors.Errorf("base-url of GitHub endpoint %s should be given", name)
+		}
+		if _, err := url.Parse(endpoint.BaseURL); err != nil {
+			return errors.Annotatef(err, "base-url of GitHub endpoint %s", name)
+		}
+		if endpoint.AppID != 0 && endpoint.AppPrivateKey == "" {
+			return errors.Errorf("GitHub App private key of GitHub endpoint %s should be given", name)
+		}
+	}
+	return nil
+}
+
+// allGithubEndpoints returns all GitHub endpoints by name, including github.com
+// with the global credentials under the empty name
+func (config *Config) allGithubEndpoints() map[string]GithubEndpointConfig {
+	endpoints := map[string]GithubEndpointConfig{
+		"": {
+			BaseURL:       githubAPIURL,
+			Host:          githubDotComHost,
+			Username:      config.GithubUsername,
+			Password:      config.GithubPassword,
+			Token:         config.GithubToken,
+			AppID:         config.GithubAppID,
+			AppPrivateKey: config.GithubAppPrivateKey,
+		},
+	}
+	for name, endpoint := range config.GithubEndpoints {
+		endpoints[name] = endpoint
+	}
+	return endpoints
+}
+
+// host returns the host where webhooks of endpoint come from
+func (endpoint GithubEndpointConfig) host() string {
+	if endpoint.Host != "" {
+		return endpoint.Host
+	}
+	u, err := url.Parse(endpoint.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// githubEndpointByHost finds the name of GitHub endpoint by webhook host
+func (config *Config) githubEndpointByHost(host string) (string, bool) {
+	if host == "" || strings.EqualFold(host, githubDotComHost) {
+		return "", true
+	}
+	for name, endpoint := range config.GithubEndpoints {
+		if strings.EqualFold(endpoint.host(), host) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// checkRepoGithubEndpoint makes sure the GitHub endpoint repo refers to exists
+func (config *Config) checkRepoGithubEndpoint(repoConfig RepoConfig) error {
+	if repoConfig.GithubEndpoint == "" {
+		return nil
+	}
+	if _, ok := config.GithubEndpoints[repoConfig.GithubEndpoint]; !ok {
+		return errors.Errorf("GitHub endpoint %s not exists", repoConfig.GithubEndpoint)
+	}
+	return nil
+}
+
+// newGithubClients creates one GitHub API client per endpoint, GitHub App
+// installation tokens are minted beforehand to find missing installations early
+func newGithubClients(config *Config, deadLetters deadLetterSink) (map[string]*githubGoogle.Client, error) {
+	githubClients := map[string]*githubGoogle.Client{}
+	for name, endpoint := range config.allGithubEndpoints() {
+		authHTTPClient, err := newGithubHTTPClient(endpoint)
+		if err != nil {
+			return nil, errors.Annotatef(err, "GitHub endpoint '%s'", name)
+		}
+		// requests wait for the rate limit, temporary errors are retried, and
+		// requests are bounded by the concurrency limit
+		githubHTTPClient := &http.Client{
+			Transport: newConcurrencyTransport(config.GithubConcurrency, &retryTransport{
+				backend:   backendGithub,
+				name:      name,
+				sink:      deadLetters,
+				transport: &githubRateLimitTransport{transport: authHTTPClient.Transport},
+			}),
+		}
+
+		githubClient := githubGoogle.NewClient(githubHTTPClient)
+		if name != "" {
+			uploadURL := endpoint.UploadURL
+			if uploadURL == "" {
+				uploadURL = endpoint.BaseURL
+			}
+			githubClient, err = githubGoogle.NewEnterpriseClient(endpoint.BaseURL, uploadURL, githubHTTPClient)
+			if err != nil {
+				return nil, errors.Annotatef(err, "GitHub endpoint '%s'", name)
+			}
+		}
+
+		if appTransport, ok := authHTTPClient.Transport.(*githubAppTransport); ok {
+			for _, owner := range config.githubOwners(name) {
+				if _, err := appTransport.installationToken(owner); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		githubClients[name] = githubClient
+	}
+	return githubClients, nil
+}
+
+// githubClientOf returns the GitHub API client of endpoint
+func (s *Server) githubClientOf(endpoint string) *githubGoogle.Client {
+	return s.githubClients[endpoint]
+}
+
+// githubWebhookHost finds the host where webhook comes from, GitHub Enterprise
+// Server sets the header, otherwise the host of repo in payload is used
+func githubWebhookHost(h http.Header, repo *githubGoogle.Repository) string {
+	if host := h.Get("X-GitHub-Enterprise-Host"); host != "" {
+		return host
+	}
+	u, err := url.Parse(repo.GetHTMLURL())
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
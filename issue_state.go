@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	githubGoogle "github.com/google/go-github/github"
+)
+
+const issueHashFileName = "issue_hashes.json"
+
+// jiraIssueState is the desired state of JIRA issue synchronized from GitHub issue
+type jiraIssueState struct {
+	summary     string
+	description string
+	// nil if GitHub issue is unassigned or the assignee is not in assignee map
+	assignee *jira.User
+	done     bool
+	// acceptable issue types, the first one is used when none matches
+	issueTypes []string
+	components []string
+}
+
+// desiredJiraIssueState computes the JIRA issue state from GitHub issue the same
+// way as presync, issue type and components are derived from GitHub labels
+func (s *Server) desiredJiraIssueState(githubIssue githubGoogle.Issue, repoConfig RepoConfig) jiraIssueState {
+	options := s.extractGithubIssueOptions(githubIssue, repoConfig)
+	state := jiraIssueState{
+		summary:     s.jiraIssueSummaryFormat(repoConfig, githubIssue.GetTitle(), options),
+		description: s.jiraIssueBodyFormat(repoConfig, githubIssue.GetTitle(), githubIssue.GetBody(), options),
+		done:        githubIssue.GetState() == "closed",
+	}
+	if name, ok := s.Config.AssigneeMap[options.githubIssueAssigneeLogin]; ok {
+		state.assignee = &jira.User{Name: name}
+	}
+
+	for _, label := range githubIssue.Labels {
+		if name, ok := repoConfig.IssueTypeLabelMap[label.GetName()]; ok {
+			state.issueTypes = append(state.issueTypes, name)
+		}
+		if name, ok := repoConfig.ComponentLabelMap[label.GetName()]; ok {
+			state.components = append(state.components, name)
+		}
+	}
+	if len(state.issueTypes) == 0 && repoConfig.JiraIssueType != "" {
+		state.issueTypes = []string{repoConfig.JiraIssueType}
+	}
+	if len(state.components) == 0 {
+		state.components = repoConfig.JiraComponents
+	}
+	return state
+}
+
+// jiraIssueDone reports whether JIRA issue is in the done status category
+func jiraIssueDone(jiraIssue jira.Issue) bool {
+	return jiraIssue.Fields != nil && jiraIssue.Fields.Status != nil &&
+		jiraIssue.Fields.Status.StatusCategory.Name == JiraStatusDoneName
+}
+
+// jiraIssueComponents returns component names of JIRA issue
+func jiraIssueComponents(jiraIssue jira.Issue) []string {
+	var names []string
+	if jiraIssue.Fields != nil {
+		for _, component := range jiraIssue.Fields.Components {
+			names = append(names, component.Name)
+		}
+	}
+	return names
+}
+
+// assigneeDiffers reports whether JIRA issue is not assigned to assignee, nil
+// assignee means JIRA issue should be unassigned
+func (target *jiraTarget) assigneeDiffers(jiraIssue jira.Issue, assignee *jira.User) (bool, error) {
+	if assignee == nil {
+		return jiraIssue.Fields != nil && jiraIssue.Fields.Assignee != nil, nil
+	}
+	isAssignee, err := target.isJiraAssignee(jiraIssue, assignee.Name)
+	if err != nil {
+		return false, err
+	}
+	return !isAssignee, nil
+}
+
+// githubIssueHash hashes everything the JIRA issue fields are derived from, so
+// that the JIRA issue of an unchanged GitHub issue needs no update
+func (s *Server) githubIssueHash(githubIssue githubGoogle.Issue, repoConfig RepoConfig) string {
+	options := s.extractGithubIssueOptions(githubIssue, repoConfig)
+	var labels []string
+	for _, label := range githubIssue.Labels {
+		labels = append(labels, label.GetName())
+	}
+	sort.Strings(labels)
+	repo, _ := json.Marshal(repoConfig)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "v1\x00%s\x00%s\x00%s\x00", githubIssue.GetTitle(), githubIssue.GetBody(), githubIssue.GetState())
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00",
+		options.githubIssueNumber, options.githubIssueLink, options.githubIssueUserLogin,
+		options.githubIssueUserLink, options.githubIssueUserName, options.githubIssueTime)
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00",
+		options.githubIssueAssigneeLogin, s.Config.AssigneeMap[options.githubIssueAssigneeLogin],
+		strings.Join(labels, ","), options.githubMilestone)
+	h.Write(repo)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// issueSyncHash combines the GitHub issue hash with the time JIRA issue was last
+// updated, so that a JIRA issue edited in JIRA, or by webhooks, is compared
+// again even if the GitHub issue is unchanged
+func issueSyncHash(githubIssueHash string, jiraIssue jira.Issue) string {
+	var updated string
+	if jiraIssue.Fields != nil {
+		updated = time.Time(jiraIssue.Fields.Updated).UTC().Format(time.RFC3339Nano)
+	}
+	return githubIssueHash + ":" + updated
+}
+
+// issueHashStore keeps the sync hash of each JIRA issue last compared without
+// failures, keyed by JIRA target and issue ID
+type issueHashStore struct {
+	mu     sync.Mutex
+	path   string
+	hashes map[string]string
+}
+
+// loadIssueHashStore reads hashes from file, a missing file is an empty store
+func loadIssueHashStore(path string) (*issueHashStore, error) {
+	store := &issueHashStore{path: path, hashes: map[string]string{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &store.hashes); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return store, nil
+}
+
+func issueHashKey(target *jiraTarget, jiraIssue jira.Issue) string {
+	return target.name + ":" + jiraIssue.ID
+}
+
+func (store *issueHashStore) get(key string) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.hashes[key]
+}
+
+func (store *issueHashStore) set(key, hash string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.hashes[key] = hash
+}
+
+// save writes hashes atomically, so that a crash never leaves a truncated store
+func (store *issueHashStore) save() error {
+	store.mu.Lock()
+	b, err := json.Marshal(store.hashes)
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, b)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+)
+
+func TestIssueSyncHash(t *testing.T) {
+	updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	issue := func(updated time.Time) jira.Issue {
+		return jira.Issue{ID: "1", Fields: &jira.IssueFields{Updated: jira.Time(updated)}}
+	}
+	hash := issueSyncHash("github", issue(updated))
+
+	if got := issueSyncHash("github", issue(updated.In(time.FixedZone("CST", 8*3600)))); got != hash {
+		t.Errorf("issueSyncHash() of the same JIRA issue in another zone = %s, want %s", got, hash)
+	}
+	if got := issueSyncHash("github", issue(updated.Add(time.Second))); got == hash {
+		t.Error("issueSyncHash() unchanged after JIRA issue is edited")
+	}
+	if got := issueSyncHash("github changed", issue(updated)); got == hash {
+		t.Error("issueSyncHash() unchanged after GitHub issue is edited")
+	}
+	if got := issueSyncHash("github", jira.Issue{ID: "1"}); got == hash {
+		t.Error("issueSyncHash() of JIRA issue without fields equals the one with updated time")
+	}
+}
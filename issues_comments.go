@@ -6,24 +6,23 @@ import (
 	logrus "github.com/sirupsen/logrus"
 )
 
-func (s *Server) handleIssueCommentCreate(l *logrus.Entry, ic githubGoogle.IssueCommentEvent) error {
+func (s *Server) handleIssueCommentCreate(l *logrus.Entry, ic githubGoogle.IssueCommentEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := ic.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[ic.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, ic.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
 	// prepare and format jira comment
-	options := s.extractGithubIssueCommentOptions(*ic.GetComment())
+	options := s.extractGithubIssueCommentOptions(*ic.GetComment(), repoConfig)
 	jiraComment := &jira.Comment{
-		Body: s.jiraIssueCommentFormat(ic.GetComment().GetBody(), options),
+		Body: s.jiraIssueCommentFormat(repoConfig, ic.GetComment().GetBody(), options),
 	}
 
 	// add jira comment
-	_, _, err = s.jiraClient.Issue.AddComment(jiraIssue.ID, jiraComment)
+	_, _, err = jiraTarget.issues("GitHub comment created").AddComment(jiraIssue.ID, jiraComment)
 	if err != nil {
 		return err
 	}
@@ -31,28 +30,27 @@ func (s *Server) handleIssueCommentCreate(l *logrus.Entry, ic githubGoogle.Issue
 	return nil
 }
 
-func (s *Server) handleIssueCommentEdit(l *logrus.Entry, ic githubGoogle.IssueCommentEvent) error {
+func (s *Server) handleIssueCommentEdit(l *logrus.Entry, ic githubGoogle.IssueCommentEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := ic.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[ic.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, ic.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
 	// find correspond jira comment
-	result, err := s.findComment(jiraIssue.ID, ic.GetComment().GetID())
+	result, err := s.findComment(repoConfig, jiraIssue.ID, ic.GetComment().GetID())
 	if err != nil {
 		return err
 	}
 
 	// prepare and format jira comment
-	options := s.extractGithubIssueCommentOptions(*ic.GetComment())
-	result.Body = s.jiraIssueCommentFormat(ic.GetComment().GetBody(), options)
+	options := s.extractGithubIssueCommentOptions(*ic.GetComment(), repoConfig)
+	result.Body = s.jiraIssueCommentFormat(repoConfig, ic.GetComment().GetBody(), options)
 
 	// update jira comment
-	_, _, err = s.jiraClient.Issue.UpdateComment(jiraIssue.ID, &result)
+	_, _, err = jiraTarget.issues("GitHub comment edited").UpdateComment(jiraIssue.ID, &result)
 	if err != nil {
 		return err
 	}
@@ -60,24 +58,23 @@ func (s *Server) handleIssueCommentEdit(l *logrus.Entry, ic githubGoogle.IssueCo
 	return nil
 }
 
-func (s *Server) handleIssueCommentDelete(l *logrus.Entry, ic githubGoogle.IssueCommentEvent) error {
+func (s *Server) handleIssueCommentDelete(l *logrus.Entry, ic githubGoogle.IssueCommentEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := ic.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[ic.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, ic.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
 	// find correspond jira issue
-	result, err := s.findComment(jiraIssue.ID, ic.GetComment().GetID())
+	result, err := s.findComment(repoConfig, jiraIssue.ID, ic.GetComment().GetID())
 	if err != nil {
 		return err
 	}
 
 	// delete jira comment
-	err = s.jiraClient.Issue.DeleteComment(jiraIssue.ID, result.ID)
+	err = jiraTarget.issues("GitHub comment deleted").DeleteComment(jiraIssue.ID, result.ID)
 	if err != nil {
 		return err
 	}
@@ -8,18 +8,29 @@ import (
 	logrus "github.com/sirupsen/logrus"
 )
 
-func (s *Server) handleIssueEventOpen(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
+func (s *Server) handleIssueEventOpen(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig, authorAssociation string) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+
+	if err := repoConfig.issueFilter().match(*i.GetIssue(), authorAssociation); err != nil {
+		l.WithError(err).Info("filter out github issue")
+		return nil
+	}
 
 	// prepare JIRA issue fields
-	options := s.extractGithubIssueOptions(*i.GetIssue())
-	jiraIssue := s.jiraIssueOpenFormat(i.GetIssue().GetID(), i.GetRepo().GetName(), i.GetIssue().GetTitle(), i.GetIssue().GetBody(), options)
+	options := s.extractGithubIssueOptions(*i.GetIssue(), repoConfig)
+	jiraIssue := s.jiraIssueOpenFormat(i.GetIssue().GetID(), repoConfig, i.GetIssue().GetTitle(), i.GetIssue().GetBody(), options)
 
 	// create JIRA issue
 	goto CreateIssueLabel
 retryCreateLabel:
 	jiraIssue.Fields.Assignee = nil
 CreateIssueLabel:
-	_, _, err := s.jiraClient.Issue.Create(&jiraIssue)
+	issues := jiraTarget.issues("GitHub issue opened")
+	if jiraIssue.Fields.Assignee != nil {
+		// assignee who does not exist is handled by creating without assignee
+		issues = jiraTarget.handledIssues("GitHub issue opened")
+	}
+	_, _, err := issues.Create(&jiraIssue)
 	if err != nil {
 		l.Debug("error create JIRA issue")
 
@@ -35,20 +46,18 @@ CreateIssueLabel:
 	return nil
 }
 
-func (s *Server) handleIssueEventClosed(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
+func (s *Server) handleIssueEventClosed(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	repoConfig := s.Config.RepoConfigMap[i.GetRepo().GetName()]
-	projectKey := repoConfig.JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
 	// do JIRA transition to "Done"
 	for _, transitionID := range repoConfig.TransitionMap[JiraTransitionDoneName] {
-		_, err = s.jiraClient.Issue.DoTransition(jiraIssue.ID, transitionID)
+		_, err = jiraTarget.handledIssues("GitHub issue closed").DoTransition(jiraIssue.ID, transitionID)
 		if err != nil {
 			return err
 		}
@@ -57,20 +66,18 @@ func (s *Server) handleIssueEventClosed(l *logrus.Entry, i githubGoogle.IssuesEv
 	return nil
 }
 
-func (s *Server) handleIssueEventReopen(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
+func (s *Server) handleIssueEventReopen(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	repoConfig := s.Config.RepoConfigMap[i.GetRepo().GetName()]
-	projectKey := repoConfig.JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
 	// do JIRA transition to "To Do"
 	for _, transitionID := range repoConfig.TransitionMap[JiraTransitionTodoName] {
-		_, err = s.jiraClient.Issue.DoTransition(jiraIssue.ID, transitionID)
+		_, err = jiraTarget.handledIssues("GitHub issue reopened").DoTransition(jiraIssue.ID, transitionID)
 		if err != nil {
 			return err
 		}
@@ -79,22 +86,21 @@ func (s *Server) handleIssueEventReopen(l *logrus.Entry, i githubGoogle.IssuesEv
 	return nil
 }
 
-func (s *Server) handleIssueEventEdit(l *logrus.Entry, i githubGoogle.IssuesEvent) (jira.Issue, error) {
+func (s *Server) handleIssueEventEdit(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig) (jira.Issue, error) {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[i.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
-		return jira.Issue{}, err
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil || done {
+		return jiraIssue, err
 	}
 
 	//  prepare JIRA issue fields
-	options := s.extractGithubIssueOptions(*i.GetIssue())
-	updateJiraIssue := s.jiraIssueUpdateFormat(jiraIssue.ID, jiraIssue.Key, i.GetIssue().GetTitle(), i.GetIssue().GetBody(), options)
+	options := s.extractGithubIssueOptions(*i.GetIssue(), repoConfig)
+	updateJiraIssue := s.jiraIssueUpdateFormat(jiraIssue.ID, jiraIssue.Key, repoConfig, i.GetIssue().GetTitle(), i.GetIssue().GetBody(), options)
 
 	// update JIRA issue
-	respJiraIssue, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+	respJiraIssue, _, err := jiraTarget.issues("GitHub issue edited").Update(&updateJiraIssue)
 	if err != nil {
 		return jira.Issue{}, err
 	}
@@ -102,16 +108,12 @@ func (s *Server) handleIssueEventEdit(l *logrus.Entry, i githubGoogle.IssuesEven
 	return *respJiraIssue, nil
 }
 
-func (s *Server) handleIssueEventAssign(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
-
-	// in case label event with creating new issue
-	time.Sleep(10 * time.Second)
+func (s *Server) handleIssueEventAssign(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[i.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
@@ -124,7 +126,7 @@ func (s *Server) handleIssueEventAssign(l *logrus.Entry, i githubGoogle.IssuesEv
 	assignee := &jira.User{Name: name}
 
 	// assign JIRA issue
-	_, err = s.jiraClient.Issue.UpdateAssignee(jiraIssue.ID, assignee)
+	_, err = jiraTarget.issues("GitHub issue assigned").UpdateAssignee(jiraIssue.ID, assignee)
 	if err != nil {
 		return err
 	}
@@ -132,13 +134,12 @@ func (s *Server) handleIssueEventAssign(l *logrus.Entry, i githubGoogle.IssuesEv
 	return nil
 }
 
-func (s *Server) handleIssueEventUnassign(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
+func (s *Server) handleIssueEventUnassign(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[i.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
@@ -148,14 +149,18 @@ func (s *Server) handleIssueEventUnassign(l *logrus.Entry, i githubGoogle.Issues
 		l.Warn("unassigned GitHub user login could not find corresponding jira user: ", i.GetAssignee().GetLogin())
 		return nil
 	}
-	if intendUnassignee != jiraIssue.Fields.Assignee.Name {
-		l.Debugf("intendUnassignee name not same as current jira assignee name %s %s", intendUnassignee, jiraIssue.Fields.Assignee.Name)
+	isAssignee, err := s.jiraTargetOf(repoConfig).isJiraAssignee(jiraIssue, intendUnassignee)
+	if err != nil {
+		return err
+	}
+	if !isAssignee {
+		l.Debugf("intendUnassignee %s is not current jira assignee", intendUnassignee)
 		return nil
 	}
 	assignee := &jira.User{}
 
 	// unassign JIRA issue
-	_, err = s.jiraClient.Issue.UpdateAssignee(jiraIssue.ID, assignee)
+	_, err = jiraTarget.issues("GitHub issue unassigned").UpdateAssignee(jiraIssue.ID, assignee)
 	if err != nil {
 		return err
 	}
@@ -165,15 +170,15 @@ func (s *Server) handleIssueEventUnassign(l *logrus.Entry, i githubGoogle.Issues
 
 // TODO: use it both in event and presync and apply this pattern to other events
 
-type eventFunc func(*logrus.Entry, *Server, githubGoogle.IssuesEvent, jira.Issue) error
+type eventFunc func(*logrus.Entry, *Server, githubGoogle.IssuesEvent, jira.Issue, RepoConfig) error
 
-func updateIssuetypeByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue) error {
+func updateIssuetypeByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
-	githubRepoName := i.GetRepo().GetName()
 	githubLabelName := i.GetLabel().GetName()
-	intendIssuetypeName, ok := s.Config.RepoConfigMap[githubRepoName].IssueTypeLabelMap[githubLabelName]
+	intendIssuetypeName, ok := repoConfig.IssueTypeLabelMap[githubLabelName]
 	if !ok {
-		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, githubRepoName)
+		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, repoConfig.fullName())
 		return nil
 	}
 
@@ -192,7 +197,7 @@ func updateIssuetypeByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEve
 	}
 
 	// update JIRA issue field
-	_, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+	_, _, err := jiraTarget.issues("GitHub issue labeled").Update(&updateJiraIssue)
 	if err != nil {
 		return err
 	}
@@ -200,13 +205,13 @@ func updateIssuetypeByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEve
 	return nil
 }
 
-func updateComponentByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue) error {
+func updateComponentByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
-	githubRepoName := i.GetRepo().GetName()
 	githubLabelName := i.GetLabel().GetName()
-	intendComponentName, ok := s.Config.RepoConfigMap[githubRepoName].ComponentLabelMap[githubLabelName]
+	intendComponentName, ok := repoConfig.ComponentLabelMap[githubLabelName]
 	if !ok {
-		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, githubRepoName)
+		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, repoConfig.fullName())
 		return nil
 	}
 
@@ -229,7 +234,7 @@ func updateComponentByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEve
 	}
 
 	// update JIRA issue field
-	_, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+	_, _, err := jiraTarget.issues("GitHub issue labeled").Update(&updateJiraIssue)
 	if err != nil {
 		return err
 	}
@@ -237,16 +242,14 @@ func updateComponentByLabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEve
 	return nil
 }
 
-func (s *Server) handleIssueEventLabel(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
-
-	// in case label event with creating new issue
-	time.Sleep(10 * time.Second)
-
-	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[i.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+func (s *Server) handleIssueEventLabel(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig, authorAssociation string) error {
+	// find correspond jira issue, it is created with auto-create
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil && err.Error() == "Issue not exists" {
+		// issue filtered out before may qualify with the new label, whatever auto-create is
+		return s.createFilteredIssue(l, i, repoConfig, authorAssociation)
+	}
+	if err != nil || done {
 		return err
 	}
 
@@ -258,7 +261,7 @@ func (s *Server) handleIssueEventLabel(l *logrus.Entry, i githubGoogle.IssuesEve
 	var errReturn error
 	for name, f := range labelEventFunc {
 		logByFunc := l.WithField("label-event-func", name)
-		if err := f(logByFunc, s, i, jiraIssue); err != nil {
+		if err := f(logByFunc, s, i, jiraIssue, repoConfig); err != nil {
 			logByFunc.WithError(err).Error("error when running")
 			errReturn = err
 		}
@@ -267,13 +270,66 @@ func (s *Server) handleIssueEventLabel(l *logrus.Entry, i githubGoogle.IssuesEve
 
 }
 
-func resetIssuetypeByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue) error {
+// createFilteredIssue creates JIRA issue with its comments, for the GitHub issue
+// which was filtered out and now qualifies
+func (s *Server) createFilteredIssue(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig, authorAssociation string) error {
+	if err := repoConfig.issueFilter().match(*i.GetIssue(), authorAssociation); err != nil {
+		l.WithError(err).Debug("github issue is still filtered out")
+		return nil
+	}
+
+	l.Info("github issue qualifies, create JIRA issue")
+	_, err := s.createJiraIssueWithComments(l, *i.GetIssue(), repoConfig)
+	return err
+}
+
+// createJiraIssueWithComments creates JIRA issue the same way as presync, with
+// all comments of the GitHub issue
+func (s *Server) createJiraIssueWithComments(l *logrus.Entry, githubIssue githubGoogle.Issue, repoConfig RepoConfig) (jira.Issue, error) {
+	jiraIssue, err := s.compareSyncIssuesCreate(l, githubIssue, repoConfig)
+	if err != nil {
+		return jira.Issue{}, err
+	}
+	return jiraIssue, s.compareSyncComments(l, jiraIssue, githubIssue, repoConfig, time.Time{})
+}
+
+// findEventIssue finds the JIRA issue of GitHub issue of event. GitHub issues
+// never synchronized, e.g. older than github-sincetime, have no JIRA issue, it
+// is created from the current GitHub issue and all its comments if auto-create
+// is enabled for the repo. The created issue already reflects the event, so
+// done is true and handlers have nothing left to apply, as well as when the
+// GitHub issue is filtered out
+func (s *Server) findEventIssue(l *logrus.Entry, repoConfig RepoConfig, eventIssue *githubGoogle.Issue) (jiraIssue jira.Issue, done bool, err error) {
+	jiraIssue, err = s.findIssue(repoConfig, eventIssue.GetID())
+	if err == nil || err.Error() != "Issue not exists" || !repoConfig.AutoCreate {
+		return jiraIssue, false, err
+	}
+
+	// the issue in payload may be outdated when events are retried or replayed
+	githubIssue, err := s.getGithubIssue(repoConfig, eventIssue.GetNumber())
+	if err != nil {
+		return jira.Issue{}, false, err
+	}
+	if err := repoConfig.issueFilter().match(githubIssue.Issue, githubIssue.AuthorAssociation); err != nil {
+		l.WithError(err).Info("filter out github issue")
+		return jira.Issue{}, true, nil
+	}
+
+	l.Info("JIRA issue not exists, create it from github issue")
+	jiraIssue, err = s.createJiraIssueWithComments(l, githubIssue.Issue, repoConfig)
+	if err != nil {
+		return jira.Issue{}, false, err
+	}
+	return jiraIssue, true, nil
+}
+
+func resetIssuetypeByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
-	githubRepoName := i.GetRepo().GetName()
 	githubLabelName := i.GetLabel().GetName()
-	intendIssuetypeName, ok := s.Config.RepoConfigMap[githubRepoName].IssueTypeLabelMap[githubLabelName]
+	intendIssuetypeName, ok := repoConfig.IssueTypeLabelMap[githubLabelName]
 	if !ok {
-		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, githubRepoName)
+		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, repoConfig.fullName())
 		return nil
 	}
 
@@ -287,13 +343,13 @@ func resetIssuetypeByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEv
 		Key: jiraIssue.Key,
 		Fields: &jira.IssueFields{
 			Type: jira.IssueType{
-				Name: s.Config.RepoConfigMap[githubRepoName].JiraIssueType,
+				Name: repoConfig.JiraIssueType,
 			},
 		},
 	}
 
 	// update JIRA issue field
-	_, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+	_, _, err := jiraTarget.issues("GitHub issue unlabeled").Update(&updateJiraIssue)
 	if err != nil {
 		return err
 	}
@@ -301,13 +357,13 @@ func resetIssuetypeByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEv
 	return nil
 }
 
-func resetComponentByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue) error {
+func resetComponentByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEvent, jiraIssue jira.Issue, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
-	githubRepoName := i.GetRepo().GetName()
 	githubLabelName := i.GetLabel().GetName()
-	intendComponentName, ok := s.Config.RepoConfigMap[githubRepoName].ComponentLabelMap[githubLabelName]
+	intendComponentName, ok := repoConfig.ComponentLabelMap[githubLabelName]
 	if !ok {
-		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, githubRepoName)
+		l.Debugf("label '%s' not in '%s' label map ", githubLabelName, repoConfig.fullName())
 		return nil
 	}
 
@@ -338,7 +394,7 @@ func resetComponentByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEv
 	}
 
 	// update JIRA issue field
-	_, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+	_, _, err := jiraTarget.issues("GitHub issue unlabeled").Update(&updateJiraIssue)
 	if err != nil {
 		return err
 	}
@@ -346,13 +402,11 @@ func resetComponentByUnlabel(l *logrus.Entry, s *Server, i githubGoogle.IssuesEv
 	return nil
 }
 
-func (s *Server) handleIssueEventUnlabel(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
+func (s *Server) handleIssueEventUnlabel(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig) error {
 
 	// find correspond jira issue
-	issueID := i.GetIssue().GetID()
-	projectKey := s.Config.RepoConfigMap[i.GetRepo().GetName()].JiraProjectKey
-	jiraIssue, err := s.findIssue(projectKey, issueID)
-	if err != nil {
+	jiraIssue, done, err := s.findEventIssue(l, repoConfig, i.GetIssue())
+	if err != nil || done {
 		return err
 	}
 
@@ -364,7 +418,7 @@ func (s *Server) handleIssueEventUnlabel(l *logrus.Entry, i githubGoogle.IssuesE
 	var errReturn error
 	for name, f := range labelEventFunc {
 		logByFunc := l.WithField("unlabel-event-func", name)
-		if err := f(logByFunc, s, i, jiraIssue); err != nil {
+		if err := f(logByFunc, s, i, jiraIssue, repoConfig); err != nil {
 			logByFunc.WithError(err).Error("error when running")
 			errReturn = err
 		}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	githubGoogle "github.com/google/go-github/github"
+	logrus "github.com/sirupsen/logrus"
+)
+
+func TestHandleIssueEventLabelCreatesFilteredIssue(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoCreate bool
+		labels     []string
+		wantCreate bool
+	}{
+		{"qualifying label without auto-create", false, []string{"type/bug"}, true},
+		{"qualifying label with auto-create", true, []string{"type/bug"}, true},
+		{"still filtered out", false, []string{"type/question"}, false},
+	}
+	for _, test := range tests {
+		filter, err := newIssueFilter(IssueFilterConfig{IncludeLabels: []string{"type/bug"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		repoConfig := RepoConfig{GithubOwner: "o", name: "r", JiraProjectKey: "TEST", AutoCreate: test.autoCreate, filter: filter}
+
+		issue := &githubGoogle.Issue{
+			ID:     githubGoogle.Int64(1001),
+			Number: githubGoogle.Int(1),
+			Title:  githubGoogle.String("panic in planner"),
+			State:  githubGoogle.String("open"),
+			User:   &githubGoogle.User{Login: githubGoogle.String("alice")},
+		}
+		for _, label := range test.labels {
+			issue.Labels = append(issue.Labels, githubGoogle.Label{Name: githubGoogle.String(label)})
+		}
+		event := githubGoogle.IssuesEvent{
+			Action: githubGoogle.String("labeled"),
+			Issue:  issue,
+			Label:  &githubGoogle.Label{Name: githubGoogle.String(test.labels[0])},
+		}
+
+		plan := &jiraDryRunPlan{}
+		s := &Server{
+			Config:     &Config{Loc: time.UTC},
+			dryRunPlan: plan,
+			jiraTargets: map[string]*jiraTarget{"": {
+				issueService: newJiraDryRunIssueService("", &fakeJiraIssueAPI{}, plan),
+				fieldIDs:     map[fieldKey]string{gitHubID: "10000"},
+			}},
+		}
+		// auto-create gets the current GitHub issue, the issue in payload is used otherwise
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(issue)
+		}))
+		githubClient := githubGoogle.NewClient(nil)
+		githubClient.BaseURL, _ = url.Parse(server.URL + "/")
+		s.githubClients = map[string]*githubGoogle.Client{"": githubClient}
+
+		err = s.handleIssueEventLabel(logrus.WithField("test", test.name), event, repoConfig, "NONE")
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		created := false
+		for _, action := range plan.actions {
+			if action.Action == "Create" {
+				created = true
+			}
+		}
+		if created != test.wantCreate {
+			t.Errorf("%s: JIRA issue created = %v, want %v", test.name, created, test.wantCreate)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"regexp"
 	"strconv"
+	"text/template"
 
 	jira "github.com/Tom-Xie/go-jira"
 	logrus "github.com/sirupsen/logrus"
@@ -22,15 +23,29 @@ const JiraTransitionDoneName = "Done"
 // JiraTransitionTodoName represent the JIRA To Do transition Name
 const JiraTransitionTodoName = "To Do"
 
+// jiraIssueAPI is the subset of JIRA issue API used by the syncer, it is
+// implemented by jira.IssueService and adapted by jiraCloudIssueService
+type jiraIssueAPI interface {
+	Create(issue *jira.Issue) (*jira.Issue, *jira.Response, error)
+	Update(issue *jira.Issue) (*jira.Issue, *jira.Response, error)
+	UpdateAssignee(issueID string, assignee *jira.User) (*jira.Response, error)
+	DoTransition(ticketID, transitionID string) (*jira.Response, error)
+	AddComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error)
+	UpdateComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error)
+	DeleteComment(issueID, commentID string) error
+	Search(jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error)
+	AddLink(issueLink *jira.IssueLink) (*jira.Response, error)
+}
+
 var reAssigneeError = regexp.MustCompile(`assignee.*User.*does not exist.`)
-var jCommentIDRegex = regexp.MustCompile("^Comment \\[\\(ID (\\d+)\\)\\|")
 
-func (s *Server) findIssue(projectKey string, issueID int64) (jira.Issue, error) {
-	githubIssueFieldKey, _ := s.Config.getFieldKey(gitHubID)
+func (s *Server) findIssue(repoConfig RepoConfig, issueID int64) (jira.Issue, error) {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+	githubIssueFieldKey, _ := jiraTarget.getFieldKey(gitHubID)
 	jql := fmt.Sprintf("project='%s' AND cf[%s] = %s",
-		projectKey, githubIssueFieldKey, strconv.FormatInt(issueID, 10))
+		repoConfig.JiraProjectKey, githubIssueFieldKey, strconv.FormatInt(issueID, 10))
 
-	jiraIssue, resp, err := s.jiraClient.Issue.Search(jql, nil)
+	jiraIssue, resp, err := jiraTarget.issueService.Search(jql, nil)
 	if err != nil {
 		return jira.Issue{}, err
 	}
@@ -40,35 +55,78 @@ func (s *Server) findIssue(projectKey string, issueID int64) (jira.Issue, error)
 		return jira.Issue{}, errors.New("Issue not exists")
 	}
 
+	if len(jiraIssue) > 1 {
+		canonical, duplicates := pickCanonicalIssue(jiraIssue)
+		l := logrus.WithFields(logrus.Fields{
+			"repo":      repoConfig.fullName(),
+			"github-id": issueID,
+		})
+		l.Warnf("%d JIRA issues found for the same GitHub issue, merge into %s", len(jiraIssue), canonical.Key)
+		if err := s.mergeDuplicateIssues(l, repoConfig, canonical, duplicates); err != nil {
+			l.WithError(err).Error("merge duplicate JIRA issues error")
+		}
+		return canonical, nil
+	}
+
 	return jiraIssue[0], nil
 }
 
-func doFindComment(jiraComments []*jira.Comment, githubCommentID int64) (result jira.Comment, found bool) {
+func doFindComment(jiraComments []*jira.Comment, commentID int64) (result jira.Comment, found bool) {
 	for _, jiraComment := range jiraComments {
-		if jCommentIDRegex.MatchString(jiraComment.Body) {
-			matches := jCommentIDRegex.FindStringSubmatch(jiraComment.Body)
-			id, _ := strconv.ParseInt(matches[1], 10, 64)
-			if githubCommentID == id {
-				found = true
-				result = *jiraComment
-				break
-			}
+		if id, ok := githubCommentID(jiraComment.Body); ok && id == commentID {
+			found = true
+			result = *jiraComment
+			break
 		}
 	}
 
 	return
 }
 
-func (s *Server) findComment(jiraIssueID string, githubCommentID int64) (jira.Comment, error) {
+// jiraCommentsPageSize is the number of JIRA comments requested per page, JIRA
+// Cloud returns at most 100 whatever is asked
+const jiraCommentsPageSize = 100
 
-	// get JIRA issue comments
-	commentsAPIEndpoint := fmt.Sprintf("rest/api/2/issue/%s/comment", jiraIssueID)
-	req, err := s.jiraClient.NewRequest("GET", commentsAPIEndpoint, nil)
-	if err != nil {
-		return jira.Comment{}, err
-	}
+// jiraCommentsPage is a page of JIRA issue comments
+type jiraCommentsPage struct {
+	StartAt    int             `json:"startAt"`
+	MaxResults int             `json:"maxResults"`
+	Total      int             `json:"total"`
+	Comments   []*jira.Comment `json:"comments"`
+}
+
+// getIssueComments gets all comments of JIRA issue page by page
+func (target *jiraTarget) getIssueComments(jiraIssueID string) (*jira.Comments, error) {
+	return getJiraIssueComments(target.client, jiraIssueID)
+}
+
+func getJiraIssueComments(client *jira.Client, jiraIssueID string) (*jira.Comments, error) {
 	jiraComments := new(jira.Comments)
-	_, err = s.jiraClient.Do(req, jiraComments)
+	for startAt := 0; ; {
+		commentsAPIEndpoint := fmt.Sprintf("rest/api/2/issue/%s/comment?startAt=%d&maxResults=%d", jiraIssueID, startAt, jiraCommentsPageSize)
+		req, err := client.NewRequest("GET", commentsAPIEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		page := new(jiraCommentsPage)
+		resp, err := client.Do(req, page)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		jiraComments.Comments = append(jiraComments.Comments, page.Comments...)
+		startAt += len(page.Comments)
+		if len(page.Comments) == 0 || startAt >= page.Total {
+			break
+		}
+	}
+	return jiraComments, nil
+}
+
+func (s *Server) findComment(repoConfig RepoConfig, jiraIssueID string, githubCommentID int64) (jira.Comment, error) {
+	// get JIRA issue comments
+	jiraComments, err := s.jiraTargetOf(repoConfig).getIssueComments(jiraIssueID)
 	if err != nil {
 		return jira.Comment{}, err
 	}
@@ -107,47 +165,74 @@ func jiraMarkdownTransform(githubIssueBodyOld string) (string, error) {
 	return githubIssueBodyNew, nil
 }
 
-func (s *Server) jiraIssueBodyFormat(githubIssueBody string, options githubIssueOptions) string {
+// jiraIssueTemplateData prepares summary and description template data from GitHub issue
+func (s *Server) jiraIssueTemplateData(repoConfig RepoConfig, githubIssueTitle, githubIssueBody string, options githubIssueOptions) jiraIssueTemplateData {
+	var labels []string
+	for _, label := range options.githubLabels {
+		labels = append(labels, label.GetName())
+	}
 
-	footnotes := fmt.Sprintf(
-		"Create issue [(#%s)|%s] from GitHub user [%s|%s]",
-		options.githubIssueNumber,
-		options.githubIssueLink,
-		options.githubIssueUserLogin,
-		options.githubIssueUserLink)
-	if len(options.githubIssueUserName) > 0 {
-		footnotes = fmt.Sprintf("%s (%s)", footnotes, options.githubIssueUserName)
+	return jiraIssueTemplateData{
+		Owner:         repoConfig.GithubOwner,
+		Repo:          repoConfig.name,
+		Title:         githubIssueTitle,
+		RawBody:       githubIssueBody,
+		Number:        options.githubIssueNumber,
+		Link:          options.githubIssueLink,
+		UserLogin:     options.githubIssueUserLogin,
+		UserLink:      options.githubIssueUserLink,
+		UserName:      options.githubIssueUserName,
+		Time:          options.githubIssueTime,
+		AssigneeLogin: options.githubIssueAssigneeLogin,
+		Labels:        labels,
+		LabelTag:      repoConfig.jiraTemplates().labelTag(labels),
+		Milestone:     options.githubMilestone,
 	}
+}
 
-	jiraIssueBody, _ := jiraMarkdownTransform(githubIssueBody)
+// executeRepoTemplate renders the repo template, falling back to the default template on error
+func executeRepoTemplate(tmpl, defaultTmpl *template.Template, data interface{}) string {
+	ret, err := executeTemplate(tmpl, data)
+	if err != nil {
+		logrus.WithError(err).Errorf("execute %s template error, use default template", tmpl.Name())
+		ret, _ = executeTemplate(defaultTmpl, data)
+	}
+	return ret
+}
 
-	ret := fmt.Sprintf(
-		"%s\n%s\n%s at %s",
-		// jiraURLLinkTransform(jiraImageLinkTransform(jiraCodeStyleTransform(githubIssueBody))),
-		jiraIssueBody,
-		"\n----\n",
-		footnotes,
-		options.githubIssueTime,
-	)
+func (s *Server) jiraIssueSummaryFormat(repoConfig RepoConfig, githubIssueTitle string, options githubIssueOptions) string {
+	templates := repoConfig.jiraTemplates()
 
-	return ret
+	data := s.jiraIssueTemplateData(repoConfig, templates.stripTitle(githubIssueTitle), "", options)
+	summary := executeRepoTemplate(templates.summary, defaultJiraTemplates.summary, data)
+
+	return truncateSummary(summary)
+}
+
+func (s *Server) jiraIssueBodyFormat(repoConfig RepoConfig, githubIssueTitle, githubIssueBody string, options githubIssueOptions) string {
+
+	data := s.jiraIssueTemplateData(repoConfig, githubIssueTitle, githubIssueBody, options)
+	// jiraURLLinkTransform(jiraImageLinkTransform(jiraCodeStyleTransform(githubIssueBody))),
+	data.Body, _ = jiraMarkdownTransform(githubIssueBody)
+
+	return executeRepoTemplate(repoConfig.jiraTemplates().description, defaultJiraTemplates.description, data)
 }
 
-func (s *Server) jiraIssueOpenFormat(githubIssueID int64, repoName, githubIssueTitle, githubIssueBody string, options githubIssueOptions, others ...interface{}) jira.Issue {
+func (s *Server) jiraIssueOpenFormat(githubIssueID int64, repoConfig RepoConfig, githubIssueTitle, githubIssueBody string, options githubIssueOptions, others ...interface{}) jira.Issue {
 
 	var components []*jira.Component
-	for _, v := range s.Config.RepoConfigMap[repoName].JiraComponents {
+	for _, v := range repoConfig.JiraComponents {
 		components = append(components, &jira.Component{Name: v})
 	}
 
 	var fixVersions []*jira.Version
-	for _, v := range s.Config.FixVersions[s.Config.RepoConfigMap[repoName].JiraProjectKey] {
+	for _, v := range s.Config.FixVersions[repoConfig.JiraProjectKey] {
 		fixVersions = append(fixVersions, &jira.Version{Name: v})
 	}
-	// fixVersions = append(fixVersions, &jira.Version{Name: s.Config.FixVersions[s.Config.RepoConfigMap[repoName].JiraProjectKey]})
+	// fixVersions = append(fixVersions, &jira.Version{Name: s.Config.FixVersions[repoConfig.JiraProjectKey]})
 
 	var affectsVersions []*jira.Version
-	for _, v := range s.Config.AffectsVersions[s.Config.RepoConfigMap[repoName].JiraProjectKey] {
+	for _, v := range s.Config.AffectsVersions[repoConfig.JiraProjectKey] {
 		affectsVersions = append(affectsVersions, &jira.Version{Name: v})
 	}
 
@@ -158,30 +243,30 @@ func (s *Server) jiraIssueOpenFormat(githubIssueID int64, repoName, githubIssueT
 
 	var labels = []string{"github"}
 	// for _, v := range options.githubLabels {
-	// 	if jiraLabel, ok := s.Config.RepoConfigMap[repoName].LabelMap[v.GetName()]; ok {
+	// 	if jiraLabel, ok := repoConfig.LabelMap[v.GetName()]; ok {
 	// 		labels = append(labels, jiraLabel)
 	// 	}
 	// }
 
 	fields := jira.IssueFields{
 		Type: jira.IssueType{
-			Name: s.Config.RepoConfigMap[repoName].JiraIssueType, // need to determine the issue type
+			Name: repoConfig.JiraIssueType, // need to determine the issue type
 		},
 		Project: jira.Project{
-			Key: s.Config.RepoConfigMap[repoName].JiraProjectKey,
+			Key: repoConfig.JiraProjectKey,
 		},
 		Components:      components,
 		FixVersions:     fixVersions,
 		AffectsVersions: affectsVersions,
 		Labels:          labels,
 		Assignee:        assignee,
-		Summary:         githubIssueTitle,
-		Description:     s.jiraIssueBodyFormat(githubIssueBody, options),
+		Summary:         s.jiraIssueSummaryFormat(repoConfig, githubIssueTitle, options),
+		Description:     s.jiraIssueBodyFormat(repoConfig, githubIssueTitle, githubIssueBody, options),
 		Unknowns:        map[string]interface{}{},
 	}
 
 	// set JIRA custom field "GitHub ID" = issue.id
-	githubIssueFieldID, err := s.Config.getFieldID(gitHubID)
+	githubIssueFieldID, err := s.jiraTargetOf(repoConfig).getFieldID(gitHubID)
 	if err == nil {
 		fields.Unknowns[githubIssueFieldID] = githubIssueID
 	}
@@ -189,8 +274,8 @@ func (s *Server) jiraIssueOpenFormat(githubIssueID int64, repoName, githubIssueT
 	// TODO: make following code more general, by using per project configuration
 
 	// issue custom field githubURL only appears in JIRA project TIKV
-	if s.Config.RepoConfigMap[repoName].JiraProjectKey == "TIKV" {
-		githubURLFieldID, err := s.Config.getFieldID(gitHubURL)
+	if repoConfig.JiraProjectKey == "TIKV" {
+		githubURLFieldID, err := s.jiraTargetOf(repoConfig).getFieldID(gitHubURL)
 		if err == nil {
 			fields.Unknowns[githubURLFieldID] = options.githubIssueLink
 		}
@@ -203,11 +288,11 @@ func (s *Server) jiraIssueOpenFormat(githubIssueID int64, repoName, githubIssueT
 	return jiraIssue
 }
 
-func (s *Server) jiraIssueUpdateFormat(jiraIssueID, jiraIssueKey, githubIssueTitle, githubIssueBody string, options githubIssueOptions, others ...interface{}) jira.Issue {
+func (s *Server) jiraIssueUpdateFormat(jiraIssueID, jiraIssueKey string, repoConfig RepoConfig, githubIssueTitle, githubIssueBody string, options githubIssueOptions, others ...interface{}) jira.Issue {
 
 	fields := jira.IssueFields{
-		Summary:     githubIssueTitle,
-		Description: s.jiraIssueBodyFormat(githubIssueBody, options),
+		Summary:     s.jiraIssueSummaryFormat(repoConfig, githubIssueTitle, options),
+		Description: s.jiraIssueBodyFormat(repoConfig, githubIssueTitle, githubIssueBody, options),
 	}
 
 	jiraIssue := jira.Issue{
@@ -219,28 +304,25 @@ func (s *Server) jiraIssueUpdateFormat(jiraIssueID, jiraIssueKey, githubIssueTit
 	return jiraIssue
 }
 
-func (s *Server) jiraIssueCommentFormat(githubIssueCommentBody string, options githubIssueCommentOptions, others ...interface{}) string {
+func (s *Server) jiraIssueCommentFormat(repoConfig RepoConfig, githubIssueCommentBody string, options githubIssueCommentOptions, others ...interface{}) string {
 
 	// note: use jira html link format to add link [name|link]
-	footnotes := fmt.Sprintf(
-		"Comment [(ID %s)|%s] from GitHub user [%s|%s]",
-		options.githubIssueCommentID,
-		options.githubIssueCommentLink,
-		options.githubIssueCommentUserLogin,
-		options.githubIssueCommentUserLink)
-	if len(options.githubIssueCommentUserName) > 0 {
-		footnotes = fmt.Sprintf("%s (%s)", footnotes, options.githubIssueCommentUserName)
-	}
-
-	jiraIssueCommentBody, _ := jiraMarkdownTransform(githubIssueCommentBody)
-
-	ret := fmt.Sprintf(
-		"%s at %s\n%s\n%s\n",
-		footnotes,
-		options.githubIssueCommentTime,
-		"\n----\n",
-		// jiraURLLinkTransform(jiraImageLinkTransform(jiraCodeStyleTransform(githubIssueCommentBody))),
-		jiraIssueCommentBody,
-	)
-	return ret
+	data := jiraCommentTemplateData{
+		Owner:     repoConfig.GithubOwner,
+		Repo:      repoConfig.name,
+		ID:        options.githubIssueCommentID,
+		RawBody:   githubIssueCommentBody,
+		Link:      options.githubIssueCommentLink,
+		UserLogin: options.githubIssueCommentUserLogin,
+		UserLink:  options.githubIssueCommentUserLink,
+		UserName:  options.githubIssueCommentUserName,
+		Time:      options.githubIssueCommentTime,
+	}
+	// jiraURLLinkTransform(jiraImageLinkTransform(jiraCodeStyleTransform(githubIssueCommentBody))),
+	data.Body, _ = jiraMarkdownTransform(githubIssueCommentBody)
+
+	ret := executeRepoTemplate(repoConfig.jiraTemplates().comment, defaultJiraTemplates.comment, data)
+
+	// comments are correlated by GitHub comment ID, see doFindComment
+	return withCommentAnchor(ret, options.githubIssueCommentID)
 }
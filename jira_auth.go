@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// JIRA authentication modes
+const (
+	jiraAuthBasic  = "basic"
+	jiraAuthToken  = "token"
+	jiraAuthOAuth1 = "oauth1"
+)
+
+// newJiraHTTPClient returns the http client used by JIRA API client according to
+// the authentication mode of JIRA target
+func newJiraHTTPClient(target JiraTargetConfig) (*http.Client, error) {
+	switch target.Auth {
+	case jiraAuthToken:
+		return &http.Client{Transport: &jiraBearerTransport{token: newSecret(target.Token)}}, nil
+	case jiraAuthOAuth1:
+		privateKey, err := newRSAKeySecret(target.OAuthPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		transport := &jiraOAuth1Transport{
+			consumerKey: target.OAuthConsumerKey,
+			accessToken: newSecret(target.OAuthAccessToken),
+			privateKey:  privateKey,
+		}
+		return &http.Client{Transport: transport}, nil
+	default:
+		// JIRA cloud uses email as username and API token as password
+		transport := &basicAuthTransport{
+			username: target.Username,
+			password: newSecret(target.Password),
+		}
+		if target.Deployment == jiraDeploymentCloud {
+			transport.password = newSecret(target.APIToken)
+		}
+		return &http.Client{Transport: transport}, nil
+	}
+}
+
+// checkJiraAuth calls JIRA myself API to make sure the authentication works
+func checkJiraAuth(l *logrus.Entry, jiraClient *jira.Client) error {
+	req, err := jiraClient.NewRequest("GET", "rest/api/2/myself", nil)
+	if err != nil {
+		return err
+	}
+	var myself struct {
+		Name        string `json:"name"`
+		AccountID   string `json:"accountId"`
+		DisplayName string `json:"displayName"`
+	}
+	resp, err := jiraClient.Do(req, &myself)
+	if err != nil {
+		return fmt.Errorf("JIRA authentication check failed: %v", jira.NewJiraError(resp, err))
+	}
+	resp.Body.Close()
+
+	l.WithFields(logrus.Fields{
+		"name":        myself.Name,
+		"accountId":   myself.AccountID,
+		"displayName": myself.DisplayName,
+	}).Info("JIRA authenticated")
+
+	return nil
+}
+
+// jiraBearerTransport authenticates requests with JIRA personal access token
+type jiraBearerTransport struct {
+	token     *secret
+	transport http.RoundTripper
+}
+
+func (t *jiraBearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token.Value()
+	if err != nil {
+		return nil, err
+	}
+	req2 := cloneRequest(req)
+	req2.Header.Set("Authorization", "Bearer "+token)
+	return transportOrDefault(t.transport).RoundTrip(req2)
+}
+
+// jiraOAuth1Transport signs requests with OAuth 1.0a RSA-SHA1, which is what JIRA
+// application links use, the access token is obtained beforehand by OAuth dance
+type jiraOAuth1Transport struct {
+	consumerKey string
+	accessToken *secret
+	privateKey  *rsaKeySecret
+	transport   http.RoundTripper
+}
+
+func (t *jiraOAuth1Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	accessToken, err := t.accessToken.Value()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     t.consumerKey,
+		"oauth_token":            accessToken,
+		"oauth_signature_method": "RSA-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_version":          "1.0",
+	}
+
+	signature, err := t.sign(req, oauthParams)
+	if err != nil {
+		return nil, err
+	}
+	oauthParams["oauth_signature"] = signature
+
+	var header []string
+	for k, v := range oauthParams {
+		header = append(header, fmt.Sprintf(`%s="%s"`, oauthEscape(k), oauthEscape(v)))
+	}
+	sort.Strings(header)
+
+	req2 := cloneRequest(req)
+	req2.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+	return transportOrDefault(t.transport).RoundTrip(req2)
+}
+
+// sign computes the RSA-SHA1 signature of the OAuth signature base string,
+// JSON request body is not part of the signature
+func (t *jiraOAuth1Transport) sign(req *http.Request, oauthParams map[string]string) (string, error) {
+	baseString := oauthBaseString(req, oauthParams)
+
+	privateKey, err := t.privateKey.Key()
+	if err != nil {
+		return "", err
+	}
+	hashed := sha1.Sum([]byte(baseString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA1, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// oauthBaseString builds the signature base string of RFC 5849 section 3.4.1,
+// parameters are encoded and then the whole normalized string is encoded again
+func oauthBaseString(req *http.Request, oauthParams map[string]string) string {
+	var params [][2]string
+	for k, v := range oauthParams {
+		params = append(params, [2]string{oauthEscape(k), oauthEscape(v)})
+	}
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			params = append(params, [2]string{oauthEscape(k), oauthEscape(v)})
+		}
+	}
+	// sorted by name and then by value, not as "name=value" strings which
+	// would put "a2" before "a"
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, param := range params {
+		pairs[i] = param[0] + "=" + param[1]
+	}
+
+	baseURL := url.URL{
+		Scheme: strings.ToLower(req.URL.Scheme),
+		Host:   strings.ToLower(req.URL.Host),
+		Path:   req.URL.EscapedPath(),
+	}
+	// default ports are excluded from the signature base string
+	baseURL.Host = strings.TrimSuffix(baseURL.Host, map[string]string{"http": ":80", "https": ":443"}[baseURL.Scheme])
+
+	return strings.Join([]string{
+		oauthEscape(strings.ToUpper(req.Method)),
+		oauthEscape(baseURL.Scheme + "://" + baseURL.Host + baseURL.Path),
+		oauthEscape(strings.Join(pairs, "&")),
+	}, "&")
+}
+
+// oauthEscape percent encodes string as RFC 3986, which OAuth 1.0a requires
+func oauthEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
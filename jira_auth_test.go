@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOAuthEscape(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"AZaz09-._~", "AZaz09-._~"},
+		{"r b", "r%20b"},
+		{"a+b*c", "a%2Bb%2Ac"},
+		{"=%3D", "%3D%253D"},
+		{"c@", "c%40"},
+		{"/path?q", "%2Fpath%3Fq"},
+		{"é", "%C3%A9"},
+	}
+	for _, test := range tests {
+		if got := oauthEscape(test.s); got != test.want {
+			t.Errorf("oauthEscape(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestOAuthBaseString(t *testing.T) {
+	rfcParams := map[string]string{
+		"oauth_consumer_key":     "9djdj82h48djs9d2",
+		"oauth_token":            "kkk9d7dh3k39sjv7",
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "137131201",
+		"oauth_nonce":            "7d8f3e4a",
+	}
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		params map[string]string
+		want   string
+	}{
+		{
+			// RFC 5849 section 3.4.1.1, with the form body moved into the query
+			name:   "RFC 5849",
+			method: "POST",
+			url:    "http://example.com/request?b5=%3D%253D&a3=a&c%40=&a2=r%20b&c2=&a3=2+q",
+			params: rfcParams,
+			want: "POST&http%3A%2F%2Fexample.com%2Frequest&a2%3Dr%2520b%26a3%3D2%2520q%26a3%3Da%26b5%3D%253D%25253D%26" +
+				"c%2540%3D%26c2%3D%26oauth_consumer_key%3D9djdj82h48djs9d2%26oauth_nonce%3D7d8f3e4a%26" +
+				"oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D137131201%26oauth_token%3Dkkk9d7dh3k39sjv7",
+		},
+		{
+			// RFC 5849 section 3.4.1.2
+			name:   "base string URI",
+			method: "get",
+			url:    "HTTP://EXAMPLE.COM:80/r%20v/X?id=123",
+			params: map[string]string{},
+			want:   "GET&http%3A%2F%2Fexample.com%2Fr%2520v%2FX&id%3D123",
+		},
+		{
+			name:   "JIRA search",
+			method: "GET",
+			url:    "https://Jira.Example.com:443/jira/rest/api/2/search?jql=project%20%3D%20TEST&startAt=0",
+			params: map[string]string{"oauth_token": "t"},
+			want:   "GET&https%3A%2F%2Fjira.example.com%2Fjira%2Frest%2Fapi%2F2%2Fsearch&jql%3Dproject%2520%253D%2520TEST%26oauth_token%3Dt%26startAt%3D0",
+		},
+		{
+			name:   "non-default port",
+			method: "GET",
+			url:    "https://jira.example.com:8443/rest",
+			params: map[string]string{},
+			want:   "GET&https%3A%2F%2Fjira.example.com%3A8443%2Frest&",
+		},
+		{
+			name:   "sorted by name before value",
+			method: "GET",
+			url:    "https://jira.example.com/rest?a2=x&a=z&a=y",
+			params: map[string]string{},
+			want:   "GET&https%3A%2F%2Fjira.example.com%2Frest&a%3Dy%26a%3Dz%26a2%3Dx",
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := oauthBaseString(req, test.params); got != test.want {
+			t.Errorf("%s: oauthBaseString() =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestJiraOAuth1Transport(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &jiraOAuth1Transport{
+		consumerKey: "sync jira",
+		accessToken: newSecret("access/token"),
+		privateKey:  &rsaKeySecret{secret: newSecret(string(keyPEM))},
+	}}
+	reqURL := server.URL + "/rest/api/2/search?jql=project%20%3D%20TEST"
+	resp, err := client.Get(reqURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !strings.HasPrefix(authorization, "OAuth ") {
+		t.Fatalf("Authorization = %q, want OAuth", authorization)
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(authorization, "OAuth "), ", ") {
+		kv := strings.SplitN(param, "=", 2)
+		v, err := url.PathUnescape(strings.Trim(kv[1], `"`))
+		if err != nil {
+			t.Fatalf("Authorization parameter %s: %v", param, err)
+		}
+		params[kv[0]] = v
+	}
+	for k, want := range map[string]string{
+		"oauth_consumer_key":     "sync jira",
+		"oauth_token":            "access/token",
+		"oauth_signature_method": "RSA-SHA1",
+		"oauth_version":          "1.0",
+	} {
+		if params[k] != want {
+			t.Errorf("%s = %q, want %q", k, params[k], want)
+		}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(params["oauth_signature"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(params, "oauth_signature")
+	req, _ := http.NewRequest("GET", reqURL, nil)
+	hashed := sha1.Sum([]byte(oauthBaseString(req, params)))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hashed[:], signature); err != nil {
+		t.Errorf("verify signature: %v", err)
+	}
+}
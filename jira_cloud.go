@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	jira "github.com/Tom-Xie/go-jira"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// JIRA deployment types
+const (
+	jiraDeploymentServer = "server"
+	jiraDeploymentCloud  = "cloud"
+)
+
+// JIRA description and comment formats
+const (
+	jiraDocFormatWiki = "wiki"
+	jiraDocFormatADF  = "adf"
+)
+
+// jiraCloudIssueService adapts the JIRA issue API to JIRA cloud, where users
+// are identified by accountId instead of username, and where descriptions and
+// comments could be written in ADF through API v3 endpoints
+type jiraCloudIssueService struct {
+	*jira.IssueService
+
+	client *jira.Client
+	users  *jiraUserResolver
+	useADF bool
+}
+
+func newJiraCloudIssueService(jiraClient *jira.Client, useADF bool) *jiraCloudIssueService {
+	return &jiraCloudIssueService{
+		IssueService: jiraClient.Issue,
+		client:       jiraClient,
+		users:        newJiraUserResolver(jiraClient),
+		useADF:       useADF,
+	}
+}
+
+// cloudIssue returns a copy of the issue whose assignee and description are
+// written in JIRA cloud format, the original issue is left untouched so that
+// callers could still retry with it
+func (s *jiraCloudIssueService) cloudIssue(issue *jira.Issue) (*jira.Issue, error) {
+	if issue.Fields == nil {
+		return issue, nil
+	}
+
+	fields := *issue.Fields
+	unknowns := map[string]interface{}{}
+	for k, v := range issue.Fields.Unknowns {
+		unknowns[k] = v
+	}
+	fields.Unknowns = unknowns
+
+	if fields.Assignee != nil {
+		// unknown or deactivated users leave the issue unassigned, the same as
+		// JIRA server where callers retry without assignee
+		accountID, err := s.users.accountID(fields.Assignee.Name)
+		if _, ok := err.(jiraUserNotFoundError); ok {
+			logrus.WithError(err).Warn("leave JIRA issue unassigned")
+		} else if err != nil {
+			return nil, err
+		} else {
+			unknowns["assignee"] = map[string]interface{}{"accountId": accountID}
+		}
+		fields.Assignee = nil
+	}
+
+	if s.useADF && fields.Description != "" {
+		unknowns["description"] = wikiToADF(fields.Description)
+		fields.Description = ""
+	}
+
+	ret := *issue
+	ret.Fields = &fields
+	return &ret, nil
+}
+
+// Create creates a JIRA issue, using API v3 when ADF is enabled
+func (s *jiraCloudIssueService) Create(issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	cloudIssue, err := s.cloudIssue(issue)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.useADF {
+		return s.IssueService.Create(cloudIssue)
+	}
+
+	req, err := s.client.NewRequest("POST", "rest/api/3/issue", cloudIssue)
+	if err != nil {
+		return nil, nil, err
+	}
+	respIssue := new(jira.Issue)
+	resp, err := s.client.Do(req, respIssue)
+	if err != nil {
+		return nil, resp, jira.NewJiraError(resp, err)
+	}
+	return respIssue, resp, nil
+}
+
+// Update updates a JIRA issue found by key, using API v3 when ADF is enabled
+func (s *jiraCloudIssueService) Update(issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	cloudIssue, err := s.cloudIssue(issue)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.useADF {
+		return s.IssueService.Update(cloudIssue)
+	}
+
+	apiEndpoint := fmt.Sprintf("rest/api/3/issue/%s", issue.Key)
+	req, err := s.client.NewRequest("PUT", apiEndpoint, cloudIssue)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.client.Do(req, nil)
+	if err != nil {
+		return nil, resp, jira.NewJiraError(resp, err)
+	}
+	ret := *issue
+	return &ret, resp, nil
+}
+
+// UpdateAssignee assigns the JIRA issue by accountId, empty assignee means unassign
+func (s *jiraCloudIssueService) UpdateAssignee(issueID string, assignee *jira.User) (*jira.Response, error) {
+	var accountID *string
+	if assignee != nil && assignee.Name != "" {
+		id, err := s.users.accountID(assignee.Name)
+		if err != nil {
+			return nil, err
+		}
+		accountID = &id
+	}
+
+	apiEndpoint := fmt.Sprintf("rest/api/2/issue/%s/assignee", issueID)
+	req, err := s.client.NewRequest("PUT", apiEndpoint, map[string]*string{"accountId": accountID})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req, nil)
+	if err != nil {
+		return resp, jira.NewJiraError(resp, err)
+	}
+	return resp, nil
+}
+
+type jiraCloudComment struct {
+	ID   string      `json:"id,omitempty"`
+	Body interface{} `json:"body"`
+}
+
+// AddComment adds a comment to the JIRA issue, using API v3 when ADF is enabled
+func (s *jiraCloudIssueService) AddComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	if !s.useADF {
+		return s.IssueService.AddComment(issueID, comment)
+	}
+
+	apiEndpoint := fmt.Sprintf("rest/api/3/issue/%s/comment", issueID)
+	return s.doADFComment("POST", apiEndpoint, comment)
+}
+
+// UpdateComment updates a comment of the JIRA issue, using API v3 when ADF is enabled
+func (s *jiraCloudIssueService) UpdateComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	if !s.useADF {
+		return s.IssueService.UpdateComment(issueID, comment)
+	}
+
+	apiEndpoint := fmt.Sprintf("rest/api/3/issue/%s/comment/%s", issueID, comment.ID)
+	return s.doADFComment("PUT", apiEndpoint, comment)
+}
+
+func (s *jiraCloudIssueService) doADFComment(method, apiEndpoint string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	req, err := s.client.NewRequest(method, apiEndpoint, jiraCloudComment{Body: wikiToADF(comment.Body)})
+	if err != nil {
+		return nil, nil, err
+	}
+	// v3 returns the body in ADF, only the ID is of interest
+	respComment := new(jiraCloudComment)
+	resp, err := s.client.Do(req, respComment)
+	if err != nil {
+		return nil, resp, jira.NewJiraError(resp, err)
+	}
+
+	ret := *comment
+	ret.ID = respComment.ID
+	return &ret, resp, nil
+}
+
+// jiraUserNotFoundError is returned when no JIRA cloud user matches the email exactly
+type jiraUserNotFoundError string
+
+func (user jiraUserNotFoundError) Error() string {
+	return fmt.Sprintf("JIRA user %s not exists", string(user))
+}
+
+// jiraUserResolver resolves configured JIRA cloud users, given either as email
+// or accountId, to accountId, results are cached for the server lifetime
+type jiraUserResolver struct {
+	client *jira.Client
+
+	mu         sync.Mutex
+	accountIDs map[string]string
+}
+
+func newJiraUserResolver(jiraClient *jira.Client) *jiraUserResolver {
+	return &jiraUserResolver{
+		client:     jiraClient,
+		accountIDs: map[string]string{},
+	}
+}
+
+func (r *jiraUserResolver) accountID(user string) (string, error) {
+	// anything not like an email is treated as accountId already
+	if !strings.Contains(user, "@") {
+		return user, nil
+	}
+
+	r.mu.Lock()
+	accountID, ok := r.accountIDs[user]
+	r.mu.Unlock()
+	if ok {
+		return accountID, nil
+	}
+
+	apiEndpoint := fmt.Sprintf("rest/api/3/user/search?query=%s", url.QueryEscape(user))
+	req, err := r.client.NewRequest("GET", apiEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	var users []struct {
+		AccountID    string `json:"accountId"`
+		EmailAddress string `json:"emailAddress"`
+		DisplayName  string `json:"displayName"`
+	}
+	resp, err := r.client.Do(req, &users)
+	if err != nil {
+		return "", jira.NewJiraError(resp, err)
+	}
+	resp.Body.Close()
+
+	// the search also matches names and emails by prefix, only an exact match is
+	// the user, users whose email is hidden by privacy settings are not found
+	for _, u := range users {
+		if strings.EqualFold(u.EmailAddress, user) || strings.EqualFold(u.DisplayName, user) {
+			accountID = u.AccountID
+			break
+		}
+	}
+	if accountID == "" {
+		return "", jiraUserNotFoundError(user)
+	}
+
+	r.mu.Lock()
+	r.accountIDs[user] = accountID
+	r.mu.Unlock()
+
+	return accountID, nil
+}
+
+// isJiraAssignee reports whether the JIRA issue is assigned to the configured user,
+// which is a username on JIRA server and an email or accountId on JIRA cloud
+func (target *jiraTarget) isJiraAssignee(jiraIssue jira.Issue, user string) (bool, error) {
+	if target.config.Deployment != jiraDeploymentCloud {
+		if jiraIssue.Fields == nil || jiraIssue.Fields.Assignee == nil {
+			return false, nil
+		}
+		return jiraIssue.Fields.Assignee.Name == user, nil
+	}
+
+	accountID, err := target.users.accountID(user)
+	if err != nil {
+		return false, err
+	}
+
+	apiEndpoint := fmt.Sprintf("rest/api/2/issue/%s?fields=assignee", jiraIssue.ID)
+	req, err := target.client.NewRequest("GET", apiEndpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	var result struct {
+		Fields struct {
+			Assignee *struct {
+				AccountID string `json:"accountId"`
+			} `json:"assignee"`
+		} `json:"fields"`
+	}
+	resp, err := target.client.Do(req, &result)
+	if err != nil {
+		return false, jira.NewJiraError(resp, err)
+	}
+	resp.Body.Close()
+
+	if result.Fields.Assignee == nil {
+		return false, nil
+	}
+	return result.Fields.Assignee.AccountID == accountID, nil
+}
+
+var reWikiLink = regexp.MustCompile(`\[([^\[\]|]+)\|([^\[\]|\s]+)\]`)
+
+// wikiToADF converts the JIRA wiki text produced by the syncer to a minimal ADF
+// document: blank lines separate paragraphs, "----" becomes a rule, [text|url]
+// becomes a link, and all other wiki markup is kept as plain text
+func wikiToADF(wiki string) map[string]interface{} {
+	var content []interface{}
+	for _, block := range strings.Split(wiki, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+		if block == "----" {
+			content = append(content, map[string]interface{}{"type": "rule"})
+			continue
+		}
+
+		var inline []interface{}
+		for i, line := range strings.Split(block, "\n") {
+			if i > 0 {
+				inline = append(inline, map[string]interface{}{"type": "hardBreak"})
+			}
+			inline = append(inline, adfInline(line)...)
+		}
+		content = append(content, map[string]interface{}{
+			"type":    "paragraph",
+			"content": inline,
+		})
+	}
+
+	if len(content) == 0 {
+		content = append(content, map[string]interface{}{"type": "paragraph", "content": []interface{}{}})
+	}
+
+	return map[string]interface{}{
+		"version": 1,
+		"type":    "doc",
+		"content": content,
+	}
+}
+
+// adfToWiki converts an ADF document produced by wikiToADF back to JIRA wiki
+func adfToWiki(doc interface{}) string {
+	var blocks []string
+	root, _ := doc.(map[string]interface{})
+	content, _ := root["content"].([]interface{})
+	for _, block := range content {
+		block, _ := block.(map[string]interface{})
+		if block["type"] == "rule" {
+			blocks = append(blocks, "----")
+			continue
+		}
+		var b strings.Builder
+		inline, _ := block["content"].([]interface{})
+		for _, node := range inline {
+			node, _ := node.(map[string]interface{})
+			switch node["type"] {
+			case "hardBreak":
+				b.WriteString("\n")
+			case "text":
+				text, _ := node["text"].(string)
+				href := ""
+				marks, _ := node["marks"].([]interface{})
+				for _, mark := range marks {
+					mark, _ := mark.(map[string]interface{})
+					if attrs, ok := mark["attrs"].(map[string]interface{}); ok && mark["type"] == "link" {
+						href, _ = attrs["href"].(string)
+					}
+				}
+				if href != "" {
+					fmt.Fprintf(&b, "[%s|%s]", text, href)
+				} else {
+					b.WriteString(text)
+				}
+			}
+		}
+		blocks = append(blocks, b.String())
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// adfInline splits a line into ADF text nodes, turning [text|url] into links
+func adfInline(line string) []interface{} {
+	var nodes []interface{}
+	text := func(s string, marks ...interface{}) {
+		if s == "" {
+			return
+		}
+		node := map[string]interface{}{"type": "text", "text": s}
+		if len(marks) != 0 {
+			node["marks"] = marks
+		}
+		nodes = append(nodes, node)
+	}
+
+	last := 0
+	for _, loc := range reWikiLink.FindAllStringSubmatchIndex(line, -1) {
+		text(line[last:loc[0]])
+		text(line[loc[2]:loc[3]], map[string]interface{}{
+			"type":  "link",
+			"attrs": map[string]interface{}{"href": line[loc[4]:loc[5]]},
+		})
+		last = loc[1]
+	}
+	text(line[last:])
+
+	return nodes
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jira "github.com/Tom-Xie/go-jira"
+)
+
+func TestWikiToADF(t *testing.T) {
+	tests := []struct {
+		name string
+		wiki string
+		// content of the ADF document in JSON
+		want string
+	}{
+		{
+			name: "empty",
+			wiki: "",
+			want: `[{"content":[],"type":"paragraph"}]`,
+		},
+		{
+			name: "paragraph",
+			wiki: "panic in planner",
+			want: `[{"content":[{"text":"panic in planner","type":"text"}],"type":"paragraph"}]`,
+		},
+		{
+			name: "paragraphs and line breaks",
+			wiki: "line 1\nline 2\n\n\n\nparagraph 2\n",
+			want: `[{"content":[{"text":"line 1","type":"text"},{"type":"hardBreak"},{"text":"line 2","type":"text"}],"type":"paragraph"},` +
+				`{"content":[{"text":"paragraph 2","type":"text"}],"type":"paragraph"}]`,
+		},
+		{
+			name: "rule",
+			wiki: "header\n\n----\n\nbody",
+			want: `[{"content":[{"text":"header","type":"text"}],"type":"paragraph"},{"type":"rule"},` +
+				`{"content":[{"text":"body","type":"text"}],"type":"paragraph"}]`,
+		},
+		{
+			name: "dashes in a paragraph are text",
+			wiki: "a\n----",
+			want: `[{"content":[{"text":"a","type":"text"},{"type":"hardBreak"},{"text":"----","type":"text"}],"type":"paragraph"}]`,
+		},
+		{
+			name: "links",
+			wiki: "Comment [(ID 1)|https://github.com/o/r/issues/1#issuecomment-1] from [foo|https://github.com/foo]",
+			want: `[{"content":[{"text":"Comment ","type":"text"},` +
+				`{"marks":[{"attrs":{"href":"https://github.com/o/r/issues/1#issuecomment-1"},"type":"link"}],"text":"(ID 1)","type":"text"},` +
+				`{"text":" from ","type":"text"},` +
+				`{"marks":[{"attrs":{"href":"https://github.com/foo"},"type":"link"}],"text":"foo","type":"text"}],"type":"paragraph"}]`,
+		},
+		{
+			name: "other markup kept as text",
+			wiki: "*bold* {code}x{code} [not a link] [a|b c]",
+			want: `[{"content":[{"text":"*bold* {code}x{code} [not a link] [a|b c]","type":"text"}],"type":"paragraph"}]`,
+		},
+		{
+			name: "anchor kept as text",
+			wiki: "body\n{anchor:github-comment-1}",
+			want: `[{"content":[{"text":"body","type":"text"},{"type":"hardBreak"},{"text":"{anchor:github-comment-1}","type":"text"}],"type":"paragraph"}]`,
+		},
+	}
+	for _, test := range tests {
+		doc := wikiToADF(test.wiki)
+		if doc["version"] != 1 || doc["type"] != "doc" {
+			t.Errorf("%s: wikiToADF() is not an ADF document: %v", test.name, doc)
+		}
+		content, err := json.Marshal(doc["content"])
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(content) != test.want {
+			t.Errorf("%s: wikiToADF() content =\n%s\nwant\n%s", test.name, content, test.want)
+		}
+	}
+}
+
+func TestADFToWiki(t *testing.T) {
+	for _, wiki := range []string{
+		"",
+		"body\n{anchor:github-comment-456}",
+		"Comment [(ID 1)|https://github.com/o/r/issues/1#issuecomment-1] from GitHub user [foo|https://github.com/foo] at now\n\n----\n\nline 1\nline 2",
+	} {
+		if got := adfToWiki(wikiToADF(wiki)); got != wiki {
+			t.Errorf("adfToWiki(wikiToADF(%q)) = %q", wiki, got)
+		}
+	}
+}
+
+func TestJiraUserResolverAccountID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// user search matches names and emails by prefix
+		switch r.URL.Query().Get("query") {
+		case "alice@example.com":
+			fmt.Fprint(w, `[{"accountId":"1","emailAddress":"alice@example.com.cn"},{"accountId":"2","emailAddress":"Alice@Example.com"}]`)
+		case "bob@example.com":
+			fmt.Fprint(w, `[{"accountId":"3","emailAddress":"bob@example.com.cn","displayName":"Bob"}]`)
+		case "hidden@example.com":
+			fmt.Fprint(w, `[{"accountId":"4","displayName":"Hidden"}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer server.Close()
+	client, err := jira.NewClient(nil, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := newJiraUserResolver(client)
+
+	tests := []struct {
+		user         string
+		want         string
+		wantNotFound bool
+	}{
+		{user: "alice@example.com", want: "2"},
+		{user: "bob@example.com", wantNotFound: true},
+		{user: "hidden@example.com", wantNotFound: true},
+		{user: "nobody@example.com", wantNotFound: true},
+		{user: "557058:f58131cb", want: "557058:f58131cb"},
+	}
+	for _, test := range tests {
+		got, err := resolver.accountID(test.user)
+		if test.wantNotFound {
+			if _, ok := err.(jiraUserNotFoundError); !ok {
+				t.Errorf("accountID(%s) = %s, %v, want jiraUserNotFoundError", test.user, got, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("accountID(%s) = %s, %v, want %s", test.user, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	jira "github.com/Tom-Xie/go-jira"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// dry-run report formats
+const (
+	dryRunFormatText = "text"
+	dryRunFormatJSON = "json"
+)
+
+// jiraFieldChange is a JIRA issue field which would be changed
+type jiraFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// jiraPlannedAction is a JIRA mutation recorded instead of being sent in dry-run mode
+type jiraPlannedAction struct {
+	Target   string            `json:"target,omitempty"`
+	Action   string            `json:"action"`
+	IssueKey string            `json:"issue-key,omitempty"`
+	Reason   string            `json:"reason"`
+	Changes  []jiraFieldChange `json:"changes,omitempty"`
+}
+
+// jiraDryRunPlan collects planned actions of all JIRA targets
+type jiraDryRunPlan struct {
+	sync.Mutex
+	actions []jiraPlannedAction
+}
+
+func (plan *jiraDryRunPlan) record(action jiraPlannedAction) {
+	logrus.WithFields(logrus.Fields{
+		"jira-target": action.Target,
+		"issue-key":   action.IssueKey,
+		"reason":      action.Reason,
+	}).Infof("dry-run: planned JIRA %s", action.Action)
+
+	plan.Lock()
+	plan.actions = append(plan.actions, action)
+	plan.Unlock()
+}
+
+// writeReport writes planned actions with a summary in text or JSON
+func (plan *jiraDryRunPlan) writeReport(w io.Writer, format string) error {
+	plan.Lock()
+	defer plan.Unlock()
+
+	summary := map[string]int{}
+	for _, action := range plan.actions {
+		summary[action.Action]++
+	}
+
+	if format == dryRunFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Summary map[string]int      `json:"summary"`
+			Actions []jiraPlannedAction `json:"actions"`
+		}{summary, plan.actions})
+	}
+
+	fmt.Fprintf(w, "dry-run: %d planned JIRA actions, nothing was sent\n", len(plan.actions))
+	names := make([]string, 0, len(summary))
+	for name := range summary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-16s %d\n", name, summary[name])
+	}
+	fmt.Fprintln(w)
+	for _, action := range plan.actions {
+		key := action.IssueKey
+		if action.Target != "" {
+			key = action.Target + ":" + key
+		}
+		fmt.Fprintf(w, "%-16s %-20s %s\n", action.Action, key, action.Reason)
+		if len(action.Changes) == 0 {
+			fmt.Fprintln(w, "    (no change)")
+		}
+		for _, change := range action.Changes {
+			fmt.Fprintf(w, "    %s: %q -> %q\n", change.Field, oneLine(change.Old), oneLine(change.New))
+		}
+	}
+	return nil
+}
+
+// oneLine shrinks value into a short line for the text report
+func oneLine(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if len(value) > 80 {
+		return value[:77] + "..."
+	}
+	return value
+}
+
+// jiraDryRunIssueService records mutations of JIRA issue API as planned actions
+// instead of sending them, searches are still sent and their results are kept
+// to find issue keys and current field values for the planned changes
+type jiraDryRunIssueService struct {
+	jiraIssueAPI
+
+	target string
+	plan   *jiraDryRunPlan
+	// why the mutation is made, given by the caller
+	reason string
+
+	mu *sync.Mutex
+	// known issues by ID and by key, including issues planned to be created
+	issues  map[string]jira.Issue
+	created *int
+}
+
+func newJiraDryRunIssueService(target string, issueService jiraIssueAPI, plan *jiraDryRunPlan) *jiraDryRunIssueService {
+	return &jiraDryRunIssueService{
+		jiraIssueAPI: issueService,
+		target:       target,
+		plan:         plan,
+		mu:           &sync.Mutex{},
+		issues:       map[string]jira.Issue{},
+		created:      new(int),
+	}
+}
+
+// withReason returns the service recording planned actions with reason
+func (s *jiraDryRunIssueService) withReason(reason string) *jiraDryRunIssueService {
+	ret := *s
+	ret.reason = reason
+	return &ret
+}
+
+// issues returns the JIRA issue API, mutations are recorded with reason in dry-run mode
+func (target *jiraTarget) issues(reason string) jiraIssueAPI {
+	if dryRun, ok := target.issueService.(*jiraDryRunIssueService); ok {
+		return dryRun.withReason(reason)
+	}
+	return target.issueService
+}
+
+// handledIssues is issues for requests whose failures are handled by the caller,
+// e.g. creating with an assignee who may not exist, or transitions which may
+// not be available in the current status, so that they are not dead letters
+func (target *jiraTarget) handledIssues(reason string) jiraIssueAPI {
+	if dryRun, ok := target.issueService.(*jiraDryRunIssueService); ok {
+		return dryRun.withReason(reason)
+	}
+	return target.handledIssueService
+}
+
+// dryRunResponse is a successful response of a request not sent
+func dryRunResponse() *jira.Response {
+	return &jira.Response{Response: &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}}
+}
+
+func (s *jiraDryRunIssueService) remember(issue jira.Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if issue.ID != "" {
+		s.issues[issue.ID] = issue
+	}
+	if issue.Key != "" {
+		s.issues[issue.Key] = issue
+	}
+}
+
+// lookup finds known issue by ID or key
+func (s *jiraDryRunIssueService) lookup(idOrKey string) (jira.Issue, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[idOrKey]
+	return issue, ok
+}
+
+// issueKey returns the key of known issue, or the ID if not known
+func (s *jiraDryRunIssueService) issueKey(idOrKey string) string {
+	if issue, ok := s.lookup(idOrKey); ok && issue.Key != "" {
+		return issue.Key
+	}
+	return idOrKey
+}
+
+func (s *jiraDryRunIssueService) record(action, issueKey string, changes []jiraFieldChange) {
+	s.plan.record(jiraPlannedAction{
+		Target:   s.target,
+		Action:   action,
+		IssueKey: issueKey,
+		Reason:   s.reason,
+		Changes:  changes,
+	})
+}
+
+func (s *jiraDryRunIssueService) Search(jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	issues, resp, err := s.jiraIssueAPI.Search(jql, options)
+	for _, issue := range issues {
+		s.remember(issue)
+	}
+	return issues, resp, err
+}
+
+// dryRunIssuePrefix prefixes the fake ID and key of issues planned to be created
+const dryRunIssuePrefix = "dry-run-"
+
+// isDryRunIssue reports whether JIRA issue is planned to be created in dry-run mode
+func isDryRunIssue(idOrKey string) bool {
+	return strings.HasPrefix(idOrKey, dryRunIssuePrefix)
+}
+
+func (s *jiraDryRunIssueService) Create(issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	s.mu.Lock()
+	*s.created++
+	id := fmt.Sprintf("%s%d", dryRunIssuePrefix, *s.created)
+	s.mu.Unlock()
+
+	// the issue is then known by its fake ID and key, so that following
+	// updates of it are planned too
+	created := jira.Issue{ID: id, Key: id, Fields: issue.Fields}
+	s.record("Create", id, jiraFieldChanges(nil, issue.Fields))
+	s.remember(created)
+	return &created, dryRunResponse(), nil
+}
+
+func (s *jiraDryRunIssueService) Update(issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	key := issue.Key
+	if key == "" {
+		key = issue.ID
+	}
+	var old *jira.IssueFields
+	if known, ok := s.lookup(key); ok {
+		old = known.Fields
+	}
+	s.record("Update", s.issueKey(key), jiraFieldChanges(old, issue.Fields))
+	return issue, dryRunResponse(), nil
+}
+
+func (s *jiraDryRunIssueService) UpdateAssignee(issueID string, assignee *jira.User) (*jira.Response, error) {
+	var old *jira.IssueFields
+	if known, ok := s.lookup(issueID); ok {
+		old = known.Fields
+	}
+	fields := &jira.IssueFields{Assignee: assignee}
+	if assignee == nil {
+		fields.Assignee = &jira.User{}
+	}
+	s.record("UpdateAssignee", s.issueKey(issueID), jiraFieldChanges(old, fields))
+	return dryRunResponse(), nil
+}
+
+func (s *jiraDryRunIssueService) DoTransition(ticketID, transitionID string) (*jira.Response, error) {
+	old := ""
+	if known, ok := s.lookup(ticketID); ok && known.Fields != nil && known.Fields.Status != nil {
+		old = known.Fields.Status.Name
+	}
+	s.record("DoTransition", s.issueKey(ticketID), []jiraFieldChange{
+		{Field: "transition", Old: old, New: transitionID},
+	})
+	return dryRunResponse(), nil
+}
+
+func (s *jiraDryRunIssueService) AddComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	s.record("AddComment", s.issueKey(issueID), []jiraFieldChange{
+		{Field: "comment", New: comment.Body},
+	})
+	return comment, dryRunResponse(), nil
+}
+
+func (s *jiraDryRunIssueService) UpdateComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	s.record("UpdateComment", s.issueKey(issueID), []jiraFieldChange{
+		{Field: "comment " + comment.ID, New: comment.Body},
+	})
+	return comment, dryRunResponse(), nil
+}
+
+func (s *jiraDryRunIssueService) DeleteComment(issueID, commentID string) error {
+	s.record("DeleteComment", s.issueKey(issueID), []jiraFieldChange{
+		{Field: "comment " + commentID},
+	})
+	return nil
+}
+
+func (s *jiraDryRunIssueService) AddLink(issueLink *jira.IssueLink) (*jira.Response, error) {
+	var inward, outward string
+	if issueLink.InwardIssue != nil {
+		inward = issueLink.InwardIssue.Key
+	}
+	if issueLink.OutwardIssue != nil {
+		outward = issueLink.OutwardIssue.Key
+	}
+	s.record("AddLink", inward, []jiraFieldChange{
+		{Field: "link " + issueLink.Type.Name, New: outward},
+	})
+	return dryRunResponse(), nil
+}
+
+// jiraFieldChanges diffs the fields set in update against the old fields,
+// fields not set in update are left untouched by JIRA and so not compared
+func jiraFieldChanges(old, update *jira.IssueFields) []jiraFieldChange {
+	if update == nil {
+		return nil
+	}
+	oldValues := map[string]string{}
+	if old != nil {
+		oldValues = jiraFieldValues(old)
+	}
+	newValues := jiraFieldValues(update)
+
+	fields := make([]string, 0, len(newValues))
+	for field := range newValues {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var changes []jiraFieldChange
+	for _, field := range fields {
+		if oldValues[field] != newValues[field] {
+			changes = append(changes, jiraFieldChange{Field: field, Old: oldValues[field], New: newValues[field]})
+		}
+	}
+	return changes
+}
+
+// jiraFieldValues returns the fields set in JIRA issue fields as strings
+func jiraFieldValues(fields *jira.IssueFields) map[string]string {
+	values := map[string]string{}
+	if fields.Summary != "" {
+		values["summary"] = fields.Summary
+	}
+	if fields.Description != "" {
+		values["description"] = fields.Description
+	}
+	if fields.Type.Name != "" {
+		values["issuetype"] = fields.Type.Name
+	}
+	if fields.Project.Key != "" {
+		values["project"] = fields.Project.Key
+	}
+	if fields.Assignee != nil {
+		values["assignee"] = fields.Assignee.Name
+	}
+	if fields.Components != nil {
+		var names []string
+		for _, component := range fields.Components {
+			names = append(names, component.Name)
+		}
+		values["components"] = strings.Join(names, ",")
+	}
+	if fields.Labels != nil {
+		values["labels"] = strings.Join(fields.Labels, ",")
+	}
+	if fields.FixVersions != nil {
+		values["fixVersions"] = jiraVersionNames(fields.FixVersions)
+	}
+	if fields.AffectsVersions != nil {
+		values["versions"] = jiraVersionNames(fields.AffectsVersions)
+	}
+	for k, v := range fields.Unknowns {
+		values[k] = jiraFieldValue(v)
+	}
+	return values
+}
+
+func jiraVersionNames(versions []*jira.Version) string {
+	var names []string
+	for _, version := range versions {
+		names = append(names, version.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// jiraFieldValue formats custom field value, numbers decoded from JIRA
+// responses are float64 and must equal the integers sent
+func jiraFieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int64:
+		return fmt.Sprint(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+
+	jira "github.com/Tom-Xie/go-jira"
+	"github.com/juju/errors"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// jiraTarget is a JIRA instance where issues are synchronized to, custom field
+// IDs are discovered per target as they differ between instances
+type jiraTarget struct {
+	name   string
+	config JiraTargetConfig
+
+	client *jira.Client
+	// JIRA issue API, adapted to the deployment type
+	issueService jiraIssueAPI
+	// the same API whose failed requests are not dead letters, for callers
+	// handling failures themselves
+	handledIssueService jiraIssueAPI
+	users               *jiraUserResolver
+
+	// JIRA custom field keys map
+	fieldIDs map[fieldKey]string
+}
+
+// defaultJiraTarget returns the global JIRA configured by "jira-*" options
+func (config *Config) defaultJiraTarget() JiraTargetConfig {
+	return JiraTargetConfig{
+		BaseURL:          config.JiraBaseURL,
+		Deployment:       config.JiraDeployment,
+		DocFormat:        config.JiraDocFormat,
+		Auth:             config.JiraAuth,
+		Username:         config.JiraUsername,
+		Password:         config.JiraPassword,
+		APIToken:         config.JiraAPIToken,
+		Token:            config.JiraToken,
+		OAuthConsumerKey: config.JiraOAuthConsumerKey,
+		OAuthPrivateKey:  config.JiraOAuthPrivateKey,
+		OAuthAccessToken: config.JiraOAuthAccessToken,
+	}
+}
+
+// allJiraTargets returns all JIRA targets by name, including the global JIRA
+// under the empty name
+func (config *Config) allJiraTargets() map[string]JiraTargetConfig {
+	targets := map[string]JiraTargetConfig{"": config.defaultJiraTarget()}
+	for name, target := range config.JiraTargets {
+		targets[name] = target
+	}
+	return targets
+}
+
+// checkJiraTargets fills default options of JIRA targets and validates them
+func (config *Config) checkJiraTargets() error {
+	if err := checkJiraTarget(config.defaultJiraTarget()); err != nil {
+		return errors.Trace(err)
+	}
+
+	for name, target := range config.JiraTargets {
+		if name == "" {
+			return errors.New("JIRA target name should be given")
+		}
+		if target.Deployment == "" {
+			target.Deployment = jiraDeploymentServer
+		}
+		if target.DocFormat == "" {
+			target.DocFormat = jiraDocFormatWiki
+		}
+		if target.Auth == "" {
+			target.Auth = jiraAuthBasic
+		}
+		if err := checkJiraTarget(target); err != nil {
+			return errors.Annotatef(err, "JIRA target %s", name)
+		}
+		config.JiraTargets[name] = target
+	}
+	return nil
+}
+
+func checkJiraTarget(target JiraTargetConfig) error {
+	if target.BaseURL == "" {
+		return errors.New("JIRA base URL should be given")
+	}
+
+	switch target.Deployment {
+	case jiraDeploymentServer, jiraDeploymentCloud:
+	default:
+		return errors.Errorf("'%s' is an invalid JIRA deployment type", target.Deployment)
+	}
+
+	switch target.Auth {
+	case jiraAuthBasic:
+		if target.Username == "" {
+			return errors.New("JIRA username should be given")
+		}
+		if target.Deployment == jiraDeploymentCloud && target.APIToken == "" {
+			return errors.New("JIRA API token should be given for JIRA cloud")
+		}
+		if target.Deployment == jiraDeploymentServer && target.Password == "" {
+			return errors.New("JIRA password should be given")
+		}
+	case jiraAuthToken:
+		if target.Token == "" {
+			return errors.New("JIRA personal access token should be given")
+		}
+	case jiraAuthOAuth1:
+		if target.OAuthConsumerKey == "" || target.OAuthPrivateKey == "" || target.OAuthAccessToken == "" {
+			return errors.New("JIRA OAuth consumer key, private key and access token should be given")
+		}
+	default:
+		return errors.Errorf("'%s' is an invalid JIRA authentication mode", target.Auth)
+	}
+
+	if target.Deployment == jiraDeploymentCloud && target.Auth != jiraAuthBasic {
+		return errors.New("JIRA cloud only supports basic authentication with API token")
+	}
+
+	switch target.DocFormat {
+	case jiraDocFormatWiki:
+	case jiraDocFormatADF:
+		if target.Deployment != jiraDeploymentCloud {
+			return errors.New("JIRA adf format is only supported by JIRA cloud")
+		}
+	default:
+		return errors.Errorf("'%s' is an invalid JIRA doc format", target.DocFormat)
+	}
+
+	return nil
+}
+
+// checkRepoJiraTarget makes sure the JIRA target repo refers to exists
+func (config *Config) checkRepoJiraTarget(repoConfig RepoConfig) error {
+	if repoConfig.JiraTarget == "" {
+		return nil
+	}
+	if _, ok := config.JiraTargets[repoConfig.JiraTarget]; !ok {
+		return errors.Errorf("JIRA target %s not exists", repoConfig.JiraTarget)
+	}
+	return nil
+}
+
+// newJiraTargets connects all JIRA targets, checks the authentication and
+// discovers custom field IDs of each target
+func newJiraTargets(config *Config, deadLetters deadLetterSink) (map[string]*jiraTarget, error) {
+	jiraTargets := map[string]*jiraTarget{}
+	for name, targetConfig := range config.allJiraTargets() {
+		target, err := newJiraTarget(name, targetConfig, config.JiraConcurrency, deadLetters)
+		if err != nil {
+			if name == "" {
+				return nil, err
+			}
+			return nil, errors.Annotatef(err, "JIRA target %s", name)
+		}
+		jiraTargets[name] = target
+	}
+	return jiraTargets, nil
+}
+
+func newJiraTarget(name string, targetConfig JiraTargetConfig, concurrency int, deadLetters deadLetterSink) (*jiraTarget, error) {
+	l := logrus.WithFields(logrus.Fields{
+		"jira-target": name,
+		"jira-auth":   targetConfig.Auth,
+	})
+
+	jiraHTTPClient, err := newJiraHTTPClient(targetConfig)
+	if err != nil {
+		return nil, err
+	}
+	// requests back off on 429, temporary errors are retried, and requests are
+	// bounded by the concurrency limit shared with requests handled by callers
+	backoff := &jiraBackoffTransport{transport: jiraHTTPClient.Transport}
+	retry := &retryTransport{
+		backend:   backendJira,
+		name:      name,
+		sink:      deadLetters,
+		transport: backoff,
+	}
+	concurrent := newConcurrencyTransport(concurrency, retry)
+	handledRetry := &retryTransport{
+		backend:   backendJira,
+		name:      name,
+		transport: backoff,
+	}
+	handledHTTPClient := *jiraHTTPClient
+	handledHTTPClient.Transport = &concurrencyTransport{sem: concurrent.sem, transport: handledRetry}
+	// failed creations are checked while holding the concurrency slot, so the
+	// check bypasses the concurrency limit and is not retried
+	checkHTTPClient := *jiraHTTPClient
+	checkHTTPClient.Transport = backoff
+	jiraHTTPClient.Transport = concurrent
+	// err only happens when url is wrong, we gaurantee the url in the Configuration
+	// reading phase, which frees us from err checking
+	jiraClient, err := jira.NewClient(jiraHTTPClient, targetConfig.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	handledJiraClient, err := jira.NewClient(&handledHTTPClient, targetConfig.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	checkJiraClient, err := jira.NewClient(&checkHTTPClient, targetConfig.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkJiraAuth(l, jiraClient); err != nil {
+		return nil, err
+	}
+
+	l.Debug("start get JIRA custom fields")
+
+	// get custom JIRA custom field ID and save it in target
+	fieldIDs, err := getJiraFiledIDs(jiraClient)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fieldIDs {
+		l.Debugf("%v: %v", k, v)
+	}
+
+	l.Debug("finish get JIRA custom fields")
+
+	target := &jiraTarget{
+		name:                name,
+		config:              targetConfig,
+		client:              jiraClient,
+		issueService:        jiraClient.Issue,
+		handledIssueService: handledJiraClient.Issue,
+		fieldIDs:            fieldIDs,
+	}
+	if targetConfig.Deployment == jiraDeploymentCloud {
+		cloudIssueService := newJiraCloudIssueService(jiraClient, targetConfig.DocFormat == jiraDocFormatADF)
+		target.issueService = cloudIssueService
+		target.users = cloudIssueService.users
+		handledCloudIssueService := newJiraCloudIssueService(handledJiraClient, targetConfig.DocFormat == jiraDocFormatADF)
+		handledCloudIssueService.users = cloudIssueService.users
+		target.handledIssueService = handledCloudIssueService
+	}
+	applied := func(req *http.Request) (bool, error) {
+		return target.requestApplied(checkJiraClient, req)
+	}
+	retry.applied = applied
+	handledRetry.applied = applied
+	return target, nil
+}
+
+var (
+	reJiraCreateIssuePath = regexp.MustCompile(`/rest/api/\d+/issue$`)
+	reJiraAddCommentPath  = regexp.MustCompile(`/rest/api/\d+/issue/([^/]+)/comment$`)
+)
+
+var errRequestNotChecked = errors.New("only creation of issues and comments could be checked")
+
+// requestApplied checks whether creation of JIRA issue or comment failed by a
+// temporary error was applied anyway, by searching the issue by GitHub ID or
+// the comment by GitHub comment ID
+func (target *jiraTarget) requestApplied(client *jira.Client, req *http.Request) (bool, error) {
+	if req.Method != http.MethodPost || req.GetBody == nil {
+		return false, errRequestNotChecked
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false, err
+	}
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if reJiraCreateIssuePath.MatchString(req.URL.Path) {
+		var issue struct {
+			Fields map[string]json.RawMessage `json:"fields"`
+		}
+		if err := json.Unmarshal(b, &issue); err != nil {
+			return false, err
+		}
+		githubIssueFieldID, err := target.getFieldID(gitHubID)
+		if err != nil {
+			return false, err
+		}
+		githubIssueFieldKey, _ := target.getFieldKey(gitHubID)
+		var githubID json.Number
+		if err := json.Unmarshal(issue.Fields[githubIssueFieldID], &githubID); err != nil || githubID == "" {
+			return false, errors.New("GitHub ID not found in JIRA issue")
+		}
+		jql := fmt.Sprintf("cf[%s] = %s", githubIssueFieldKey, githubID)
+		var project struct {
+			Key string `json:"key"`
+		}
+		if json.Unmarshal(issue.Fields["project"], &project) == nil && project.Key != "" {
+			jql = fmt.Sprintf("project='%s' AND %s", project.Key, jql)
+		}
+		issues, resp, err := client.Issue.Search(jql, &jira.SearchOptions{MaxResults: 1})
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		return len(issues) != 0, nil
+	}
+
+	if matches := reJiraAddCommentPath.FindStringSubmatch(req.URL.Path); matches != nil {
+		var comment struct {
+			Body interface{} `json:"body"`
+		}
+		if err := json.Unmarshal(b, &comment); err != nil {
+			return false, err
+		}
+		commentBody, ok := comment.Body.(string)
+		if !ok {
+			commentBody = adfToWiki(comment.Body)
+		}
+		commentID, ok := githubCommentID(commentBody)
+		if !ok {
+			return false, errors.New("GitHub comment ID not found in JIRA comment")
+		}
+		jiraComments, err := getJiraIssueComments(client, matches[1])
+		if err != nil {
+			return false, err
+		}
+		_, found := doFindComment(jiraComments.Comments, commentID)
+		return found, nil
+	}
+
+	return false, errRequestNotChecked
+}
+
+// jiraTargetOf returns the JIRA target where issues of repo are synchronized to
+func (s *Server) jiraTargetOf(repoConfig RepoConfig) *jiraTarget {
+	return s.jiraTargets[repoConfig.JiraTarget]
+}
+
+// browseURL returns the URL of JIRA issue in browser
+func (target *jiraTarget) browseURL(jiraIssueKey string) string {
+	u, _ := url.Parse(target.config.BaseURL)
+	u.Path = path.Join(u.Path, "browse", jiraIssueKey)
+	return u.String()
+}
+
+// return number string with 'customfield_' prefix, e.g. "customfield_10109"
+func (target *jiraTarget) getFieldID(key fieldKey) (string, error) {
+	val, ok := target.fieldIDs[key]
+	if !ok {
+		return "", errors.New("fieldKey not exists")
+	}
+	return fmt.Sprintf("customfield_%s", val), nil
+}
+
+// return just number string, e.g. "10109"
+func (target *jiraTarget) getFieldKey(key fieldKey) (string, error) {
+	val, ok := target.fieldIDs[key]
+	if !ok {
+		return "", errors.New("fieldKey not exists")
+	}
+	return val, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jira "github.com/Tom-Xie/go-jira"
+)
+
+func TestJiraRequestApplied(t *testing.T) {
+	// issue with GitHub ID 123 and comment of GitHub comment 456 exist in JIRA
+	var searched string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/2/search":
+			searched = r.URL.Query().Get("jql")
+			if searched == "project='TIDB' AND cf[10000] = 123" {
+				fmt.Fprint(w, `{"startAt":0,"maxResults":1,"total":1,"issues":[{"id":"1","key":"TIDB-1"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"startAt":0,"maxResults":1,"total":0,"issues":[]}`)
+		case "/rest/api/2/issue/1/comment":
+			fmt.Fprint(w, `{"startAt":0,"maxResults":100,"total":1,"comments":[{"id":"1","body":"body\n{anchor:github-comment-456}"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client, err := jira.NewClient(nil, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &jiraTarget{fieldIDs: map[fieldKey]string{gitHubID: "10000"}}
+
+	comment := func(body string) interface{} { return map[string]interface{}{"body": body} }
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:   "created issue",
+			method: http.MethodPost,
+			path:   "rest/api/2/issue",
+			body:   map[string]interface{}{"fields": map[string]interface{}{"project": map[string]string{"key": "TIDB"}, "customfield_10000": 123}},
+			want:   true,
+		},
+		{
+			name:   "issue not created",
+			method: http.MethodPost,
+			path:   "rest/api/3/issue",
+			body:   map[string]interface{}{"fields": map[string]interface{}{"project": map[string]string{"key": "TIDB"}, "customfield_10000": 124}},
+			want:   false,
+		},
+		{
+			name:    "issue without GitHub ID",
+			method:  http.MethodPost,
+			path:    "rest/api/2/issue",
+			body:    map[string]interface{}{"fields": map[string]interface{}{"summary": "panic"}},
+			wantErr: true,
+		},
+		{
+			name:   "added comment",
+			method: http.MethodPost,
+			path:   "rest/api/2/issue/1/comment",
+			body:   comment("body\n{anchor:github-comment-456}"),
+			want:   true,
+		},
+		{
+			name:   "comment not added",
+			method: http.MethodPost,
+			path:   "rest/api/2/issue/1/comment",
+			body:   comment("Comment [(ID 457)|https://github.com/o/r/issues/1#issuecomment-457] from GitHub user [foo|https://github.com/foo] at now\n\n----\n\nbody"),
+			want:   false,
+		},
+		{
+			name:   "added ADF comment",
+			method: http.MethodPost,
+			path:   "rest/api/3/issue/1/comment",
+			body:   map[string]interface{}{"body": wikiToADF("body\n{anchor:github-comment-456}")},
+			want:   true,
+		},
+		{
+			name:    "comment without GitHub comment ID",
+			method:  http.MethodPost,
+			path:    "rest/api/2/issue/1/comment",
+			body:    comment("body"),
+			wantErr: true,
+		},
+		{
+			name:    "transition",
+			method:  http.MethodPost,
+			path:    "rest/api/2/issue/1/transitions",
+			body:    map[string]interface{}{"transition": map[string]string{"id": "1"}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		b, _ := json.Marshal(test.body)
+		req, _ := http.NewRequest(test.method, server.URL+"/"+test.path, bytes.NewReader(b))
+		got, err := target.requestApplied(client, req)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: requestApplied() = %v, want error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: requestApplied() = %v, %v, want %v (searched %q)", test.name, got, err, test.want, searched)
+		}
+	}
+}
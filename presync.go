@@ -2,127 +2,300 @@ package main
 
 import (
 	"fmt"
-	"net/url"
-	"path"
-	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	jira "github.com/Tom-Xie/go-jira"
 	githubGoogle "github.com/google/go-github/github"
 	logrus "github.com/sirupsen/logrus"
 )
 
-const lastSyncTimeFileName = "last_sync_time.txt"
+// presync progress is logged at this interval
+const presyncProgressInterval = 30 * time.Second
 
-func (s *Server) compareSyncIssues(l *logrus.Entry) error {
+// compareSyncIssues synchronizes all repos, or only the given "owner/name" repos,
+// and returns the number of issues failed to synchronize, which are dead letters
+// already. The error is only returned when repos or their issues failed to be
+// listed, or on shutdown
+func (s *Server) compareSyncIssues(l *logrus.Entry, repos []string) (int, error) {
 
-	s.readLastSyncTime(l)
-
-	// sync all repos in parallel
-	var errReturn error
+	// sync all repos in parallel, at most PresyncWorkers issues at the same time
 	var wgRepo sync.WaitGroup
-	for repoName := range s.Config.RepoConfigMap {
+	workers := make(chan struct{}, s.Config.PresyncWorkers)
+	progress := &presyncProgress{}
+	stopProgress := progress.report(l, presyncProgressInterval)
+	defer stopProgress()
+
+	repoConfigs, errReturn := s.syncRepoConfigs(l, repos)
+	atomic.AddInt32(&progress.repos, int32(len(repoConfigs)))
+	for _, repoConfig := range repoConfigs {
+
+		// repos not started yet are synchronized next time
+		if s.stopping() {
+			break
+		}
 
-		// find all github issues in repo
-		allGithubIssues, err := s.getGithubIssuesByRepo(l, s.Config.RepoConfigMap[repoName].GithubOwner, repoName)
+		// find all github issues in repo updated since the cursor of repo
+		since := s.repoSince(repoConfig)
+		allGithubIssues, err := s.getGithubIssuesByRepo(l, repoConfig, since)
 		if err != nil {
-			l.WithError(err).Errorf("getGithubIssuesByRepo error occur of %s", repoName)
+			l.WithError(err).Errorf("getGithubIssuesByRepo error occur of %s", repoConfig.fullName())
 			errReturn = err
+			atomic.AddInt32(&progress.reposDone, 1)
 			continue // error only affects this repo, just pass
 		}
 		l.WithFields(logrus.Fields{
-			"repoName": repoName,
+			"repoName": repoConfig.fullName(),
 			"number":   len(allGithubIssues),
 		}).Debug("finish get all github issues")
+		atomic.AddInt32(&progress.issues, int32(len(allGithubIssues)))
 
 		wgRepo.Add(1)
 
-		go func(l *logrus.Entry, repoName string) {
+		go func(l *logrus.Entry, repoConfig RepoConfig, since time.Time, allGithubIssues []*githubRepoIssue) {
 
 			defer wgRepo.Done()
+			defer atomic.AddInt32(&progress.reposDone, 1)
+
+			// cursor of repo is advanced only if no issue failed, and all issues
+			// are synchronized before shutdown
+			var repoFailed int32
+			var interrupted bool
+			fail := func(githubIssue *githubRepoIssue, err error) {
+				atomic.AddInt32(&progress.failed, 1)
+				atomic.AddInt32(&repoFailed, 1)
+				s.deadLetters.putFailedIssue(repoConfig, githubIssue.GetNumber(), err)
+			}
+
+			// issues filtered out, or failed to create, are not updated
+			filtered := map[int64]bool{}
+			// issues created in this run, whose comments are all synchronized
+			created := map[int64]bool{}
 
 			// create all github corresponding issue in squential(order)
 			for _, githubIssue := range allGithubIssues {
 
-				_, err := s.findIssue(s.Config.RepoConfigMap[repoName].JiraProjectKey, githubIssue.GetID())
+				if s.stopping() {
+					interrupted = true
+					break
+				}
+
+				workers <- struct{}{}
+				unlock := s.issueLocks.lock(repoConfig, githubIssue.GetID())
+				_, err := s.findIssue(repoConfig, githubIssue.GetID())
 
 				// TODO: mark not exists/created issue and pass the following issue updating, and remove below findIssue()
 				if err != nil {
 					if err.Error() == "Issue not exists" {
-						// create new JIRA issue as not found the githubIssue
-						_, err = s.compareSyncIssuesCreate(l, *githubIssue, repoName)
-						if err != nil {
+						if err := repoConfig.issueFilter().match(githubIssue.Issue, githubIssue.AuthorAssociation); err != nil {
+							l.WithError(err).Debugf("filter out github issue %s", githubIssue.GetHTMLURL())
+							filtered[githubIssue.GetID()] = true
+						} else if _, err = s.compareSyncIssuesCreate(l, githubIssue.Issue, repoConfig); err != nil {
+							// create new JIRA issue as not found the githubIssue
 							l.WithError(err).Error("error with compareSyncIssuesCreate")
-							continue // error only affects this issue, just pass
+							fail(githubIssue, err)
+							filtered[githubIssue.GetID()] = true // error only affects this issue, just pass
+						} else {
+							created[githubIssue.GetID()] = true
 						}
 					} else {
 						l.WithError(err).Error("error with findIssue when compareSyncIssuesCreate")
-						continue // just pass current issue
+						fail(githubIssue, err)
+						filtered[githubIssue.GetID()] = true // just pass current issue
 					}
 				}
+				unlock()
+				<-workers
+				if filtered[githubIssue.GetID()] {
+					atomic.AddInt32(&progress.issuesDone, 1)
+				}
 			}
 
 			// update all issues and comments in parallel
 			var wgIssue sync.WaitGroup
 			for _, githubIssue := range allGithubIssues {
 
+				if interrupted || s.stopping() {
+					interrupted = true
+					break
+				}
+				if filtered[githubIssue.GetID()] {
+					continue
+				}
+
 				wgIssue.Add(1)
+				workers <- struct{}{}
 
-				go func(l *logrus.Entry, githubIssue *githubGoogle.Issue) {
+				// comments older than the cursor were never synchronized to
+				// issues created in this run
+				commentsSince := since
+				if created[githubIssue.GetID()] {
+					commentsSince = time.Time{}
+				}
+				go func(l *logrus.Entry, githubIssue *githubRepoIssue, commentsSince time.Time) {
 					defer wgIssue.Done()
+					defer func() { <-workers }()
+					defer atomic.AddInt32(&progress.issuesDone, 1)
 
-					jiraIssue, err := s.findIssue(s.Config.RepoConfigMap[repoName].JiraProjectKey, githubIssue.GetID())
-					if err != nil {
-						l.WithError(err).Error("error with findIssue when compareSyncIssuesUpdate&compareSyncComments")
-						return
-					}
-
-					u, _ := url.Parse(s.Config.JiraBaseURL)
-					u.Path = path.Join(u.Path, "browse", jiraIssue.Key)
-					l = l.WithFields(
-						logrus.Fields{
-							"githubIssueURL": githubIssue.GetHTMLURL(),
-							"jiraIssueURL":   u.String(),
-						})
-
-					// update the corresponding JIRA issue according to github issue
-					l.Debug("start compareSyncIssuesUpdate")
-					err = s.compareSyncIssuesUpdate(l, jiraIssue, *githubIssue, repoName)
-					if err != nil {
-						l.WithError(err).Error("error with compareSyncIssuesUpdate")
-						return
-					}
-					l.Debug("finish compareSyncIssuesUpdate")
-
-					l = l.WithFields(
-						logrus.Fields{
-							"event-type": "compareSyncComments",
-						})
-					// both need to compare JIRA issue comments with GitHub issue comments
-					l.Debug("start compareSyncComments")
-					err = s.compareSyncComments(l, jiraIssue, *githubIssue, repoName)
-					if err != nil {
-						l.WithError(err).Error("error with compareSyncComments")
+					unlock := s.issueLocks.lock(repoConfig, githubIssue.GetID())
+					defer unlock()
+					if err := s.compareSyncIssue(l, githubIssue.Issue, repoConfig, commentsSince); err != nil {
+						fail(githubIssue, err)
 					}
-					l.Debug("finish compareSyncComments")
-
-				}(l, githubIssue)
+				}(l, githubIssue, commentsSince)
 			}
 
 			wgIssue.Wait()
 
-		}(l, repoName)
+			if atomic.LoadInt32(&repoFailed) == 0 && !interrupted && !s.Config.DryRun {
+				s.advanceRepoCursor(l, repoConfig, since, allGithubIssues)
+			}
+
+		}(l, repoConfig, since, allGithubIssues)
 
 	}
 	wgRepo.Wait()
 
-	s.writeLastSyncTime(l)
+	if !s.Config.DryRun {
+		if err := s.issueHashes.save(); err != nil {
+			l.WithError(err).Warnf("write \"%s\" error", issueHashFileName)
+		}
+	}
+
+	progress.log(l)
+	if errReturn == nil && s.stopping() {
+		errReturn = errShuttingDown
+	}
+	return int(atomic.LoadInt32(&progress.failed)), errReturn
+}
+
+// presyncProgress counts repos and issues processed by presync
+type presyncProgress struct {
+	repos, reposDone   int32
+	issues, issuesDone int32
+	failed             int32
+}
+
+func (p *presyncProgress) log(l *logrus.Entry) {
+	l.WithFields(logrus.Fields{
+		"repos":  fmt.Sprintf("%d/%d", atomic.LoadInt32(&p.reposDone), atomic.LoadInt32(&p.repos)),
+		"issues": fmt.Sprintf("%d/%d", atomic.LoadInt32(&p.issuesDone), atomic.LoadInt32(&p.issues)),
+		"failed": atomic.LoadInt32(&p.failed),
+	}).Info("presync progress")
+}
+
+// report logs progress every interval until the returned stop function is called
+func (p *presyncProgress) report(l *logrus.Entry, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				p.log(l)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// compareSyncIssue updates the JIRA issue of GitHub issue and its comments created
+// or updated since the given time, zero time means all comments
+func (s *Server) compareSyncIssue(l *logrus.Entry, githubIssue githubGoogle.Issue, repoConfig RepoConfig, commentsSince time.Time) error {
+	jiraIssue, err := s.findIssue(repoConfig, githubIssue.GetID())
+	if err != nil {
+		l.WithError(err).Error("error with findIssue when compareSyncIssuesUpdate&compareSyncComments")
+		return err
+	}
+
+	l = l.WithFields(
+		logrus.Fields{
+			"githubIssueURL": githubIssue.GetHTMLURL(),
+			"jiraIssueURL":   s.jiraTargetOf(repoConfig).browseURL(jiraIssue.Key),
+		})
+
+	// update the corresponding JIRA issue according to github issue
+	l.Debug("start compareSyncIssuesUpdate")
+	err = s.compareSyncIssuesUpdate(l, jiraIssue, githubIssue, repoConfig)
+	if err != nil {
+		l.WithError(err).Error("error with compareSyncIssuesUpdate")
+		return err
+	}
+	l.Debug("finish compareSyncIssuesUpdate")
+
+	l = l.WithFields(
+		logrus.Fields{
+			"event-type": "compareSyncComments",
+		})
+	// both need to compare JIRA issue comments with GitHub issue comments
+	l.Debug("start compareSyncComments")
+	err = s.compareSyncComments(l, jiraIssue, githubIssue, repoConfig, commentsSince)
+	if err != nil {
+		l.WithError(err).Error("error with compareSyncComments")
+		return err
+	}
+	l.Debug("finish compareSyncComments")
+	return nil
+}
+
+// syncRepoConfigs returns configs of all repos to synchronize, which are explicitly
+// configured repos and repos of organizations discovered by routing rules, only
+// the given repos are returned if any
+func (s *Server) syncRepoConfigs(l *logrus.Entry, repos []string) ([]RepoConfig, error) {
+	var errReturn error
+	var repoConfigs []RepoConfig
+	seen := map[string]bool{}
+	for key, repoConfig := range s.Config.RepoConfigMap {
+		seen[key] = true
+		repoConfigs = append(repoConfigs, repoConfig)
+	}
+
+	for endpoint := range s.githubClients {
+		for _, owner := range s.Config.routeOwners(endpoint) {
+			repos, err := s.getGithubReposByOrg(l, endpoint, owner)
+			if err != nil {
+				l.WithError(err).Errorf("getGithubReposByOrg error occur of %s", owner)
+				errReturn = err
+				continue // error only affects repos of this owner, just pass
+			}
+			for _, repo := range repos {
+				owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+				key := repoConfigKey(endpoint, owner, name)
+				if seen[key] {
+					continue
+				}
+				if repoConfig, ok := s.Config.getRepoConfig(endpoint, owner, name); ok {
+					seen[key] = true
+					repoConfigs = append(repoConfigs, repoConfig)
+				}
+			}
+		}
+	}
 
-	return errReturn
+	if len(repos) != 0 {
+		var selected []RepoConfig
+		for _, repoConfig := range repoConfigs {
+			if repoConfig.matchAny(repos) {
+				selected = append(selected, repoConfig)
+			}
+		}
+		repoConfigs = selected
+	}
+
+	return repoConfigs, errReturn
 }
 
 // compareSyncIssuesCreate is simlar to handleIssueEventOpen, however due to different formats of GitHub issue/issueEvent representations, we make this function instead of call handleIssueEventOpen() directly
-func (s *Server) compareSyncIssuesCreate(l *logrus.Entry, githubIssue githubGoogle.Issue, repoName string) (jira.Issue, error) {
+func (s *Server) compareSyncIssuesCreate(l *logrus.Entry, githubIssue githubGoogle.Issue, repoConfig RepoConfig) (jira.Issue, error) {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+
 	l = l.WithFields(
 		logrus.Fields{
 			"githubIssueURL": githubIssue.GetHTMLURL(),
@@ -134,8 +307,8 @@ func (s *Server) compareSyncIssuesCreate(l *logrus.Entry, githubIssue githubGoog
 	githubIssueStatus := githubIssue.GetState()
 
 	// prepare JIRA issue fields
-	options := s.extractGithubIssueOptions(githubIssue)
-	jiraIssue := s.jiraIssueOpenFormat(githubIssueID, repoName, githubIssueTitle, githubIssueBody, options)
+	options := s.extractGithubIssueOptions(githubIssue, repoConfig)
+	jiraIssue := s.jiraIssueOpenFormat(githubIssueID, repoConfig, githubIssueTitle, githubIssueBody, options)
 
 	// check if githubIssueAssigneeLogin in AssigneeMap, for adding AssigneeMap later
 	if _, ok := s.Config.AssigneeMap[options.githubIssueAssigneeLogin]; !ok && options.githubIssueAssigneeLogin != "" {
@@ -149,7 +322,12 @@ func (s *Server) compareSyncIssuesCreate(l *logrus.Entry, githubIssue githubGoog
 retryCreateLabel:
 	jiraIssue.Fields.Assignee = nil
 CreateIssueLabel:
-	respJiraIssue, resp, err := s.jiraClient.Issue.Create(&jiraIssue)
+	issues := jiraTarget.issues("GitHub issue missing in JIRA")
+	if jiraIssue.Fields.Assignee != nil {
+		// assignee who does not exist is handled by creating without assignee
+		issues = jiraTarget.handledIssues("GitHub issue missing in JIRA")
+	}
+	respJiraIssue, resp, err := issues.Create(&jiraIssue)
 	if err != nil {
 		if reAssigneeError.MatchString(err.Error()) {
 			l.Warn("retry create JIRA issue without assignee: ", jiraIssue.Fields.Assignee.Name)
@@ -164,10 +342,9 @@ CreateIssueLabel:
 	// sync JIRA issue assignee speratelly, this approach maybe daunting ??
 
 	// sync JIRA issue transition status, "To Do" to "Done"
-	repoConfig := s.Config.RepoConfigMap[repoName]
 	if githubIssueStatus == "closed" {
 		for _, transitionID := range repoConfig.TransitionMap[JiraTransitionDoneName] {
-			_, err = s.jiraClient.Issue.DoTransition(respJiraIssue.ID, transitionID)
+			_, err = jiraTarget.handledIssues("GitHub issue is closed").DoTransition(respJiraIssue.ID, transitionID)
 			if err != nil {
 				l.WithError(err).Error("JIRA issue transition to closed error")
 				break
@@ -178,7 +355,7 @@ CreateIssueLabel:
 	// sync jiraIssue issue type according to github label
 	var issueTypes []string
 	for _, label := range githubIssue.Labels {
-		if name, ok := s.Config.RepoConfigMap[repoName].IssueTypeLabelMap[label.GetName()]; ok {
+		if name, ok := repoConfig.IssueTypeLabelMap[label.GetName()]; ok {
 			issueTypes = append(issueTypes, name)
 		}
 	}
@@ -191,7 +368,7 @@ CreateIssueLabel:
 				},
 			},
 		}
-		_, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+		_, _, err := jiraTarget.issues("issue type of GitHub labels").Update(&updateJiraIssue)
 		if err != nil {
 			l.WithError(err).Error("create JIRA issue change issueType by label error")
 		}
@@ -200,7 +377,7 @@ CreateIssueLabel:
 	// sync jiraIssue component according to github label
 	var components []*jira.Component
 	for _, label := range githubIssue.Labels {
-		if name, ok := s.Config.RepoConfigMap[repoName].ComponentLabelMap[label.GetName()]; ok {
+		if name, ok := repoConfig.ComponentLabelMap[label.GetName()]; ok {
 			components = append(components, &jira.Component{Name: name})
 		}
 	}
@@ -212,7 +389,7 @@ CreateIssueLabel:
 				Components: components,
 			},
 		}
-		_, _, err := s.jiraClient.Issue.Update(&updateJiraIssue)
+		_, _, err := jiraTarget.issues("components of GitHub labels").Update(&updateJiraIssue)
 		if err != nil {
 			l.WithError(err).Error("create JIRA issue change components by label error")
 		}
@@ -223,124 +400,123 @@ CreateIssueLabel:
 	return *respJiraIssue, nil
 }
 
-// compareSyncIssuesUpdate has similar intention as above compareSyncIssuesCreate
-func (s *Server) compareSyncIssuesUpdate(l *logrus.Entry, jiraIssue jira.Issue, githubIssue githubGoogle.Issue, repoName string) error {
-
-	githubIssueTitle := githubIssue.GetTitle()
-	githubIssueBody := githubIssue.GetBody()
+// compareSyncIssuesUpdate has similar intention as above compareSyncIssuesCreate,
+// the desired JIRA issue state is diffed against the current one and only the
+// differences are written
+func (s *Server) compareSyncIssuesUpdate(l *logrus.Entry, jiraIssue jira.Issue, githubIssue githubGoogle.Issue, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+
+	// JIRA issue is skipped if neither the GitHub issue nor the JIRA issue changed
+	// since the last synchronization
+	hashKey := issueHashKey(jiraTarget, jiraIssue)
+	hash := issueSyncHash(s.githubIssueHash(githubIssue, repoConfig), jiraIssue)
+	if s.issueHashes.get(hashKey) == hash {
+		l.Debug("github and JIRA issue unchanged since last synchronization, skip compareSyncIssuesUpdate")
+		return nil
+	}
 
-	//  prepare JIRA issue fields
-	options := s.extractGithubIssueOptions(githubIssue)
-	updateJiraIssue := s.jiraIssueUpdateFormat(jiraIssue.ID, jiraIssue.Key, githubIssueTitle, githubIssueBody, options)
+	if jiraIssue.Fields == nil {
+		jiraIssue.Fields = &jira.IssueFields{}
+	}
+	desired := s.desiredJiraIssueState(githubIssue, repoConfig)
+	var failed bool
 
-	// update JIRA issue
-	_, resp, err := s.jiraClient.Issue.Update(&updateJiraIssue)
-	if err != nil {
-		return err
+	// update JIRA issue summary and description
+	if desired.summary != jiraIssue.Fields.Summary || strings.TrimSpace(desired.description) != strings.TrimSpace(jiraIssue.Fields.Description) {
+		updateJiraIssue := jira.Issue{
+			Key: jiraIssue.Key,
+			ID:  jiraIssue.ID,
+			Fields: &jira.IssueFields{
+				Summary:     desired.summary,
+				Description: desired.description,
+			},
+		}
+		_, resp, err := jiraTarget.issues("GitHub issue title and body").Update(&updateJiraIssue)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
 	}
-	resp.Body.Close()
 
 	// sync JIRA issue assignee, this approach maybe daunting
-	var assignee *jira.User
-	if name, ok := s.Config.AssigneeMap[options.githubIssueAssigneeLogin]; ok {
-		assignee = &jira.User{Name: name}
-	} else if options.githubIssueAssigneeLogin != "" {
-		l.Warn("GitHub user login not find: ", options.githubIssueAssigneeLogin)
+	if githubIssue.GetAssignee() != nil && desired.assignee == nil {
+		l.Warn("GitHub user login not find: ", githubIssue.GetAssignee().GetLogin())
 	}
 	// if jira issue already have assignee, should we overwrite it ??
-	_, err = s.jiraClient.Issue.UpdateAssignee(jiraIssue.ID, assignee)
+	differs, err := jiraTarget.assigneeDiffers(jiraIssue, desired.assignee)
 	if err != nil {
-		l.WithError(err).Warn("assign JIRA issue to user error ", options.githubIssueUserLogin)
+		l.WithError(err).Warn("get JIRA issue assignee error")
+		failed = true
+	} else if differs {
+		_, err = jiraTarget.issues("GitHub issue assignee").UpdateAssignee(jiraIssue.ID, desired.assignee)
+		if err != nil {
+			l.WithError(err).Warn("assign JIRA issue to user error ", githubIssue.GetUser().GetLogin())
+			failed = true
+		}
 	}
 
 	// sync issue transition status
-	repoConfig := s.Config.RepoConfigMap[repoName]
-	if githubIssue.GetState() == "closed" {
-		if jiraIssue.Fields.Status.StatusCategory.Name != JiraStatusDoneName {
-			for _, transitionID := range repoConfig.TransitionMap[JiraTransitionDoneName] {
-				_, err = s.jiraClient.Issue.DoTransition(jiraIssue.ID, transitionID)
-				if err != nil {
-					l.WithError(err).Error("JIRA issue transition to closed error")
-					break
-				}
-			}
-		}
-	} else if githubIssue.GetState() == "open" {
-		if jiraIssue.Fields.Status.StatusCategory.Name == JiraStatusDoneName {
-			for _, transitionID := range repoConfig.TransitionMap[JiraTransitionTodoName] {
-				_, err = s.jiraClient.Issue.DoTransition(jiraIssue.ID, transitionID)
-				if err != nil {
-					l.WithError(err).Error("JIRA issue transition to open error")
-					break
-				}
+	if desired.done && !jiraIssueDone(jiraIssue) {
+		for _, transitionID := range repoConfig.TransitionMap[JiraTransitionDoneName] {
+			_, err = jiraTarget.handledIssues("GitHub issue is closed").DoTransition(jiraIssue.ID, transitionID)
+			if err != nil {
+				l.WithError(err).Error("JIRA issue transition to closed error")
+				failed = true
+				break
 			}
 		}
-	}
-
-	// sync jiraIssue issue type according to github label
-	var issueTypes []string
-	for _, label := range githubIssue.Labels {
-		if name, ok := s.Config.RepoConfigMap[repoName].IssueTypeLabelMap[label.GetName()]; ok {
-			issueTypes = append(issueTypes, name)
-		}
-	}
-
-	var toUpdateIssueTypeName string
-	if len(issueTypes) != 0 {
-		var found bool
-		for _, name := range issueTypes {
-			if name == jiraIssue.Fields.Type.Name {
-				found = true
+	} else if !desired.done && jiraIssueDone(jiraIssue) {
+		for _, transitionID := range repoConfig.TransitionMap[JiraTransitionTodoName] {
+			_, err = jiraTarget.handledIssues("GitHub issue is open").DoTransition(jiraIssue.ID, transitionID)
+			if err != nil {
+				l.WithError(err).Error("JIRA issue transition to open error")
+				failed = true
 				break
 			}
 		}
-		if !found {
-			toUpdateIssueTypeName = issueTypes[0]
-		}
-
-	} else {
-		toUpdateIssueTypeName = s.Config.RepoConfigMap[repoName].JiraIssueType
 	}
 
-	if toUpdateIssueTypeName != "" {
+	// sync jiraIssue issue type according to github label
+	if len(desired.issueTypes) != 0 && !containsString(desired.issueTypes, jiraIssue.Fields.Type.Name) {
 		updateIssueTypeJiraIssue := jira.Issue{
 			Key: jiraIssue.Key,
 			Fields: &jira.IssueFields{
 				Type: jira.IssueType{
-					Name: toUpdateIssueTypeName,
+					Name: desired.issueTypes[0],
 				},
 			},
 		}
-		_, _, err = s.jiraClient.Issue.Update(&updateIssueTypeJiraIssue)
+		_, _, err = jiraTarget.issues("issue type of GitHub labels").Update(&updateIssueTypeJiraIssue)
 		if err != nil {
 			l.WithError(err).Error("update JIRA issue change issueType by label error")
+			failed = true
 		}
 	}
 
 	// sync jiraIssue component according to github label
 	// ?? only S_GitHub or S_JIRA U S_GitHub
-	var components []*jira.Component
-	for _, label := range githubIssue.Labels {
-		if name, ok := s.Config.RepoConfigMap[repoName].ComponentLabelMap[label.GetName()]; ok {
-			components = append(components, &jira.Component{Name: name})
-		}
-	}
-	if len(components) == 0 {
-		for _, v := range s.Config.RepoConfigMap[repoName].JiraComponents {
+	if !sameStrings(desired.components, jiraIssueComponents(jiraIssue)) {
+		var components []*jira.Component
+		for _, v := range desired.components {
 			components = append(components, &jira.Component{Name: v})
 		}
+		updateComponentsJiraIssue := jira.Issue{
+			Key: jiraIssue.Key,
+			Fields: &jira.IssueFields{
+				Components: components,
+			},
+		}
+		_, _, err = jiraTarget.issues("components of GitHub labels").Update(&updateComponentsJiraIssue)
+		if err != nil {
+			l.WithError(err).Error("update JIRA issue change components by label error")
+			failed = true
+		}
 	}
 
-	// ?? maybe update only if !same(jiraIssue.Fields.Components, components)
-	updateComponentsJiraIssue := jira.Issue{
-		Key: jiraIssue.Key,
-		Fields: &jira.IssueFields{
-			Components: components,
-		},
-	}
-	_, _, err = s.jiraClient.Issue.Update(&updateComponentsJiraIssue)
-	if err != nil {
-		l.WithError(err).Error("update JIRA issue change components by label error")
+	// GitHub issue is synchronized again next time if anything failed, and
+	// compared once more if anything was written, as writes update the JIRA issue
+	if !failed {
+		s.issueHashes.set(hashKey, hash)
 	}
 
 	l.Debug("finish compareSyncIssuesUpdate")
@@ -348,47 +524,26 @@ func (s *Server) compareSyncIssuesUpdate(l *logrus.Entry, jiraIssue jira.Issue,
 	return nil
 }
 
-func (s *Server) compareSyncComments(l *logrus.Entry, jiraIssue jira.Issue, githubIssue githubGoogle.Issue, repoName string) error {
-
-	// we don't handle the return pagination temporarily, as the default return maxResults is 1048576 as https://internal.pingcap.net/jira/rest/api/2/issue/TIDB-1353/comment?startAt=0&maxResults=1048576
-	// get JIRA issue comments
-	commentsAPIEndpoint := fmt.Sprintf("rest/api/2/issue/%s/comment", jiraIssue.ID)
-	req, err := s.jiraClient.NewRequest("GET", commentsAPIEndpoint, nil)
-	if err != nil {
-		return err
-	}
+// compareSyncComments synchronizes GitHub comments created or updated since the given time,
+// and deletes JIRA comments whose GitHub comment is deleted
+func (s *Server) compareSyncComments(l *logrus.Entry, jiraIssue jira.Issue, githubIssue githubGoogle.Issue, repoConfig RepoConfig, since time.Time) error {
+	// get JIRA issue comments, issues planned to be created in dry-run mode have none
 	jiraComments := new(jira.Comments)
-	resp, err := s.jiraClient.Do(req, jiraComments)
-	if err != nil {
-		return err
+	var err error
+	if !isDryRunIssue(jiraIssue.ID) {
+		jiraComments, err = s.jiraTargetOf(repoConfig).getIssueComments(jiraIssue.ID)
+		if err != nil {
+			return err
+		}
 	}
-	resp.Body.Close()
 
 	// github comments number equals zero, still need to compare comments and delete
-	if githubIssue.GetComments() == 0 {
-		for _, jiraComment := range jiraComments.Comments {
-
-			var jCommentIDRegex = regexp.MustCompile("^Comment \\[\\(ID (\\d+)\\)\\|")
-			if !jCommentIDRegex.MatchString(jiraComment.Body) {
-				continue
-			}
-
-			// correspond github issue comment is deleted
-			// delete JIRA issue comment
-			err = s.jiraClient.Issue.DeleteComment(jiraIssue.ID, jiraComment.ID)
-			if err != nil {
-				l.WithError(err).Warn("Delete JIRA comment error")
-				continue
-			}
-
+	var githubComments []*githubGoogle.IssueComment
+	if githubIssue.GetComments() != 0 {
+		githubComments, err = s.getGithubIssueComments(repoConfig, githubIssue.GetNumber(), since)
+		if err != nil {
+			return err
 		}
-
-		return err
-	}
-
-	githubComments, err := s.getGithubIssueComments(s.Config.RepoConfigMap[repoName].GithubOwner, repoName, githubIssue.GetNumber())
-	if err != nil {
-		return err
 	}
 
 	// l.Debug("start traverse github Comments")
@@ -400,14 +555,14 @@ func (s *Server) compareSyncComments(l *logrus.Entry, jiraIssue jira.Issue, gith
 
 		// situation 1: github issue comment has corresponding jira issue comment, update it
 		if found {
-			err = s.compareSyncCommentsUpdate(l, jiraIssue, result, *githubComment)
+			err = s.compareSyncCommentsUpdate(l, jiraIssue, result, *githubComment, repoConfig)
 			if err != nil {
 				l.WithError(err).Warn("compareSyncCommentsUpdate error")
 				continue
 			}
 		} else {
 			//  situation 2: github issue comment has not corresponding jira issue comment exists, create it
-			err = s.compareSyncCommentsCreate(l, jiraIssue, *githubComment)
+			err = s.compareSyncCommentsCreate(l, jiraIssue, *githubComment, repoConfig)
 			if err != nil {
 				l.WithError(err).Warn("compareSyncCommentsCreate error")
 				continue
@@ -415,20 +570,80 @@ func (s *Server) compareSyncComments(l *logrus.Entry, jiraIssue jira.Issue, gith
 		}
 	}
 
+	if e := s.compareSyncCommentsDelete(l, jiraIssue, githubIssue, jiraComments.Comments, githubComments, repoConfig, since); e != nil {
+		err = e
+	}
+
+	return err
+}
+
+// compareSyncCommentsDelete deletes JIRA comments synchronized from GitHub comments
+// which no longer exist. Comments listed since the given time are only part of the
+// GitHub comments, so all of them are listed again when JIRA has more synchronized
+// comments than the GitHub issue, and nothing is deleted unless the list has at
+// least as many comments as the GitHub issue, so that a partial list never deletes
+// comments still on GitHub
+func (s *Server) compareSyncCommentsDelete(l *logrus.Entry, jiraIssue jira.Issue, githubIssue githubGoogle.Issue, jiraComments []*jira.Comment, githubComments []*githubGoogle.IssueComment, repoConfig RepoConfig, since time.Time) error {
+	synced := map[int64][]*jira.Comment{}
+	for _, jiraComment := range jiraComments {
+		if id, ok := githubCommentID(jiraComment.Body); ok {
+			synced[id] = append(synced[id], jiraComment)
+		}
+	}
+	// no GitHub comment is deleted, or the deleted one is replaced by a new one
+	// not synchronized yet, which is found next time
+	if len(synced) <= githubIssue.GetComments() {
+		return nil
+	}
+
+	var err error
+	if !since.IsZero() && githubIssue.GetComments() != 0 {
+		githubComments, err = s.getGithubIssueComments(repoConfig, githubIssue.GetNumber(), time.Time{})
+		if err != nil {
+			return err
+		}
+	}
+	if len(githubComments) < githubIssue.GetComments() {
+		l.WithFields(logrus.Fields{
+			"listed":   len(githubComments),
+			"comments": githubIssue.GetComments(),
+		}).Warn("GitHub returned partial comments, skip deleting JIRA comments")
+		return nil
+	}
+
+	exists := map[int64]bool{}
+	for _, githubComment := range githubComments {
+		exists[githubComment.GetID()] = true
+	}
+	jiraTarget := s.jiraTargetOf(repoConfig)
+	for id, comments := range synced {
+		if exists[id] {
+			continue
+		}
+		// correspond github issue comment is deleted
+		// delete JIRA issue comment
+		for _, jiraComment := range comments {
+			if e := jiraTarget.issues("GitHub comment deleted").DeleteComment(jiraIssue.ID, jiraComment.ID); e != nil {
+				l.WithError(e).WithField("githubCommentID", id).Warn("Delete JIRA comment error")
+				err = e
+			}
+		}
+	}
 	return err
 }
 
 // compareSyncCommentsCreate is simlar to handleIssueCommentCreate, however due to different formats of GitHub issue/issueEvent representations, we make this function instead of call handleIssueCommentCreate() directly
 // could just use jiraIssue.ID to improve performance
-func (s *Server) compareSyncCommentsCreate(l *logrus.Entry, jiraIssue jira.Issue, githubComment githubGoogle.IssueComment) error {
+func (s *Server) compareSyncCommentsCreate(l *logrus.Entry, jiraIssue jira.Issue, githubComment githubGoogle.IssueComment, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	githubCommentBody := githubComment.GetBody()
-	options := s.extractGithubIssueCommentOptions(githubComment)
+	options := s.extractGithubIssueCommentOptions(githubComment, repoConfig)
 	jiraComment := &jira.Comment{
-		Body: s.jiraIssueCommentFormat(githubCommentBody, options),
+		Body: s.jiraIssueCommentFormat(repoConfig, githubCommentBody, options),
 	}
 
-	_, resp, err := s.jiraClient.Issue.AddComment(jiraIssue.ID, jiraComment)
+	_, resp, err := jiraTarget.issues("GitHub comment missing in JIRA").AddComment(jiraIssue.ID, jiraComment)
 	if err != nil {
 		return err
 	}
@@ -438,15 +653,21 @@ func (s *Server) compareSyncCommentsCreate(l *logrus.Entry, jiraIssue jira.Issue
 }
 
 // compareSyncCommentsUpdate has similar intention as above compareSyncCommentsCreate
-func (s *Server) compareSyncCommentsUpdate(l *logrus.Entry, jiraIssue jira.Issue, jiraComment jira.Comment, githubComment githubGoogle.IssueComment) error {
+func (s *Server) compareSyncCommentsUpdate(l *logrus.Entry, jiraIssue jira.Issue, jiraComment jira.Comment, githubComment githubGoogle.IssueComment, repoConfig RepoConfig) error {
+	jiraTarget := s.jiraTargetOf(repoConfig)
 
 	result := jiraComment
 
 	githubCommentBody := githubComment.GetBody()
-	options := s.extractGithubIssueCommentOptions(githubComment)
-	result.Body = s.jiraIssueCommentFormat(githubCommentBody, options)
+	options := s.extractGithubIssueCommentOptions(githubComment, repoConfig)
+	result.Body = s.jiraIssueCommentFormat(repoConfig, githubCommentBody, options)
+
+	// comments are only written when they actually differ
+	if strings.TrimSpace(result.Body) == strings.TrimSpace(jiraComment.Body) {
+		return nil
+	}
 
-	_, resp, err := s.jiraClient.Issue.UpdateComment(jiraIssue.ID, &result)
+	_, resp, err := jiraTarget.issues("GitHub comment body").UpdateComment(jiraIssue.ID, &result)
 	if err != nil {
 		return err
 	}
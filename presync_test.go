@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	githubGoogle "github.com/google/go-github/github"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// fakeJiraIssueAPI records mutations of JIRA issues instead of sending them,
+// searches return the found issues
+type fakeJiraIssueAPI struct {
+	jiraIssueAPI
+	found   []jira.Issue
+	deleted []string
+	calls   []string
+}
+
+func (f *fakeJiraIssueAPI) Search(jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	return f.found, dryRunResponse(), nil
+}
+
+func (f *fakeJiraIssueAPI) DeleteComment(issueID, commentID string) error {
+	f.deleted = append(f.deleted, commentID)
+	return nil
+}
+
+func (f *fakeJiraIssueAPI) AddComment(issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	f.calls = append(f.calls, fmt.Sprintf("AddComment %s %q", issueID, comment.Body))
+	return comment, dryRunResponse(), nil
+}
+
+func (f *fakeJiraIssueAPI) AddLink(issueLink *jira.IssueLink) (*jira.Response, error) {
+	f.calls = append(f.calls, fmt.Sprintf("AddLink %s %s %s", issueLink.InwardIssue.Key, issueLink.Type.Name, issueLink.OutwardIssue.Key))
+	return dryRunResponse(), nil
+}
+
+func (f *fakeJiraIssueAPI) DoTransition(ticketID, transitionID string) (*jira.Response, error) {
+	f.calls = append(f.calls, fmt.Sprintf("DoTransition %s %s", ticketID, transitionID))
+	return dryRunResponse(), nil
+}
+
+func (f *fakeJiraIssueAPI) Update(issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Update %s %v", issue.Key, issue.Fields.Unknowns))
+	return issue, dryRunResponse(), nil
+}
+
+func TestGithubCommentID(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		wantID int64
+		wantOK bool
+	}{
+		{"default footer", "Comment [(ID 123)|https://github.com/o/r/issues/1#issuecomment-123] from GitHub user [foo|https://github.com/foo] at 2018-10-01\n\n----\n\nbody", 123, true},
+		{"anchor", "custom comment\n{anchor:github-comment-456}", 456, true},
+		{"escaped ADF anchor", "custom comment\n\\{anchor:github-comment-789\\}", 789, true},
+		{"footer not at the beginning", "quoted: Comment [(ID 123)|https://github.com]", 0, false},
+		{"not synchronized", "written in JIRA", 0, false},
+	}
+	for _, test := range tests {
+		id, ok := githubCommentID(test.body)
+		if id != test.wantID || ok != test.wantOK {
+			t.Errorf("%s: githubCommentID() = %d, %v, want %d, %v", test.name, id, ok, test.wantID, test.wantOK)
+		}
+	}
+}
+
+func TestCompareSyncCommentsDelete(t *testing.T) {
+	footer := func(id string) *jira.Comment {
+		return &jira.Comment{ID: "j" + id, Body: "Comment [(ID " + id + ")|https://github.com/o/r/issues/1#issuecomment-" + id + "] from GitHub user [foo|https://github.com/foo]\n\n----\n\nbody"}
+	}
+	anchor := func(id string) *jira.Comment {
+		return &jira.Comment{ID: "j" + id, Body: "body\n{anchor:github-comment-" + id + "}"}
+	}
+	escapedAnchor := func(id string) *jira.Comment {
+		return &jira.Comment{ID: "j" + id, Body: "body\n\\{anchor:github-comment-" + id + "\\}"}
+	}
+	jiraOnly := &jira.Comment{ID: "j0", Body: "written in JIRA"}
+
+	tests := []struct {
+		name           string
+		jiraComments   []*jira.Comment
+		githubCount    int
+		githubComments []int64
+		// GitHub comments listed again when since is given
+		listed      []int64
+		since       time.Time
+		dryRun      bool
+		wantDeleted []string
+		wantListed  bool
+	}{
+		{
+			name:           "all comments still on GitHub",
+			jiraComments:   []*jira.Comment{footer("1"), anchor("2")},
+			githubCount:    2,
+			githubComments: []int64{1, 2},
+		},
+		{
+			name:           "default footer missing on GitHub",
+			jiraComments:   []*jira.Comment{footer("1"), footer("2")},
+			githubCount:    1,
+			githubComments: []int64{1},
+			wantDeleted:    []string{"j2"},
+		},
+		{
+			name:           "anchor missing on GitHub",
+			jiraComments:   []*jira.Comment{footer("1"), anchor("2")},
+			githubCount:    1,
+			githubComments: []int64{1},
+			wantDeleted:    []string{"j2"},
+		},
+		{
+			name:           "escaped ADF anchor missing on GitHub",
+			jiraComments:   []*jira.Comment{escapedAnchor("1"), escapedAnchor("2")},
+			githubCount:    1,
+			githubComments: []int64{2},
+			wantDeleted:    []string{"j1"},
+		},
+		{
+			name:           "comments not synchronized from GitHub are kept",
+			jiraComments:   []*jira.Comment{jiraOnly, footer("1")},
+			githubCount:    0,
+			githubComments: nil,
+			wantDeleted:    []string{"j1"},
+		},
+		{
+			name:           "deleted comment replaced by a new one not synchronized yet",
+			jiraComments:   []*jira.Comment{footer("1"), footer("2")},
+			githubCount:    2,
+			githubComments: []int64{1, 3},
+		},
+		{
+			name:           "partial GitHub comments",
+			jiraComments:   []*jira.Comment{footer("1"), footer("2"), footer("3")},
+			githubCount:    2,
+			githubComments: []int64{1},
+		},
+		{
+			name:           "all comments listed again since the given time",
+			jiraComments:   []*jira.Comment{footer("1"), footer("2"), footer("3")},
+			githubCount:    2,
+			githubComments: []int64{3},
+			listed:         []int64{1, 3},
+			since:          time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC),
+			wantDeleted:    []string{"j2"},
+			wantListed:     true,
+		},
+		{
+			name:           "dry-run",
+			jiraComments:   []*jira.Comment{footer("1"), anchor("2")},
+			githubCount:    1,
+			githubComments: []int64{1},
+			dryRun:         true,
+			wantDeleted:    []string{"j2"},
+		},
+	}
+	for _, test := range tests {
+		listed := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			listed = r.URL.Query().Get("since") == ""
+			var comments []*githubGoogle.IssueComment
+			for _, id := range test.listed {
+				comments = append(comments, &githubGoogle.IssueComment{ID: githubGoogle.Int64(id)})
+			}
+			json.NewEncoder(w).Encode(comments)
+		}))
+		githubClient := githubGoogle.NewClient(nil)
+		githubClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+		fake := &fakeJiraIssueAPI{}
+		target := &jiraTarget{issueService: fake}
+		s := &Server{
+			githubClients: map[string]*githubGoogle.Client{"": githubClient},
+			jiraTargets:   map[string]*jiraTarget{"": target},
+		}
+		if test.dryRun {
+			s.dryRunPlan = &jiraDryRunPlan{}
+			target.issueService = newJiraDryRunIssueService("", fake, s.dryRunPlan)
+		}
+
+		var githubComments []*githubGoogle.IssueComment
+		for _, id := range test.githubComments {
+			githubComments = append(githubComments, &githubGoogle.IssueComment{ID: githubGoogle.Int64(id)})
+		}
+		githubIssue := githubGoogle.Issue{Number: githubGoogle.Int(1), Comments: githubGoogle.Int(test.githubCount)}
+		repoConfig := RepoConfig{GithubOwner: "o", name: "r"}
+
+		err := s.compareSyncCommentsDelete(logrus.WithField("test", test.name), jira.Issue{ID: "10001"}, githubIssue, test.jiraComments, githubComments, repoConfig, test.since)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if listed != test.wantListed {
+			t.Errorf("%s: GitHub comments listed again = %v, want %v", test.name, listed, test.wantListed)
+		}
+
+		deleted := fake.deleted
+		if test.dryRun {
+			if len(fake.deleted) != 0 {
+				t.Errorf("%s: comments %v deleted in dry-run mode", test.name, fake.deleted)
+			}
+			deleted = nil
+			for _, action := range s.dryRunPlan.actions {
+				if action.Action == "DeleteComment" {
+					deleted = append(deleted, action.Changes[0].Field[len("comment "):])
+				}
+			}
+		}
+		sort.Strings(deleted)
+		if !reflect.DeepEqual(deleted, test.wantDeleted) {
+			t.Errorf("%s: deleted %v, want %v", test.name, deleted, test.wantDeleted)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+const (
+	// rate limited requests are retried at most this many times
+	rateLimitMaxRetries = 5
+	// pause of GitHub primary rate limit without valid X-RateLimit-Reset and Retry-After
+	githubRateLimitMinBackoff = time.Minute
+	// backoff of JIRA 429 responses without Retry-After
+	jiraBackoffMin = time.Second
+	jiraBackoffMax = 2 * time.Minute
+)
+
+// concurrencyTransport bounds the number of concurrent requests to a backend
+type concurrencyTransport struct {
+	sem       chan struct{}
+	transport http.RoundTripper
+}
+
+func newConcurrencyTransport(limit int, transport http.RoundTripper) *concurrencyTransport {
+	return &concurrencyTransport{sem: make(chan struct{}, limit), transport: transport}
+}
+
+func (t *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.sem <- struct{}{}
+	defer func() { <-t.sem }()
+	return transportOrDefault(t.transport).RoundTrip(req)
+}
+
+// rewindRequest returns a copy of request with a fresh body to send it again,
+// false if the body could not be read again
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	ret := req.WithContext(req.Context())
+	ret.Body = body
+	return ret, true
+}
+
+// sleepRequest waits for d, or returns the error of request context canceled before
+func sleepRequest(req *http.Request, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// discardResponse drains and closes response body, so that the connection is reused
+func discardResponse(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// retryAfter parses Retry-After header in seconds or HTTP date
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// githubRateLimitTransport pauses all requests of a GitHub endpoint when the
+// primary rate limit is exhausted until X-RateLimit-Reset, and retries requests
+// hitting the secondary rate limit after Retry-After
+type githubRateLimitTransport struct {
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	resumeAt time.Time
+}
+
+func (t *githubRateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+		resp, err := transportOrDefault(t.transport).RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		exhausted := resp.Header.Get("X-RateLimit-Remaining") == "0"
+		var delay time.Duration
+		if exhausted {
+			// without valid reset time, wait for Retry-After or the minimum backoff
+			delay = githubRateLimitMinBackoff
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				delay = time.Until(time.Unix(reset, 0))
+			} else if d, ok := retryAfter(resp.Header); ok {
+				delay = d
+			}
+			t.pause(delay)
+		}
+
+		limited := resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests
+		if d, ok := retryAfter(resp.Header); ok && limited {
+			delay = d
+			t.pause(delay)
+		} else if !limited || !exhausted {
+			return resp, nil
+		}
+
+		if attempt >= rateLimitMaxRetries {
+			return resp, nil
+		}
+		retry, ok := rewindRequest(req)
+		if !ok {
+			return resp, nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"url":     req.URL.String(),
+			"attempt": attempt + 1,
+		}).Warnf("GitHub rate limit exceeded, retry after %v", delay)
+		discardResponse(resp)
+		req = retry
+	}
+}
+
+// pause makes requests wait for d from now
+func (t *githubRateLimitTransport) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if resumeAt := time.Now().Add(d); resumeAt.After(t.resumeAt) {
+		t.resumeAt = resumeAt
+	}
+}
+
+// wait waits until rate limit reset, or until request is canceled
+func (t *githubRateLimitTransport) wait(req *http.Request) error {
+	t.mu.Lock()
+	d := time.Until(t.resumeAt)
+	t.mu.Unlock()
+	if d > 0 {
+		logrus.Debugf("wait %v for GitHub rate limit reset", d)
+		return sleepRequest(req, d)
+	}
+	return nil
+}
+
+// jiraBackoffTransport slows down all requests of a JIRA target adaptively, the
+// delay doubles on each 429 response and halves on each successful response
+type jiraBackoffTransport struct {
+	transport http.RoundTripper
+
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func (t *jiraBackoffTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		t.mu.Lock()
+		delay := t.delay
+		t.mu.Unlock()
+		if delay > 0 {
+			if err := sleepRequest(req, delay); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := transportOrDefault(t.transport).RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.mu.Lock()
+			if t.delay /= 2; t.delay < jiraBackoffMin/10 {
+				t.delay = 0
+			}
+			t.mu.Unlock()
+			return resp, nil
+		}
+
+		t.mu.Lock()
+		t.delay *= 2
+		if t.delay < jiraBackoffMin {
+			t.delay = jiraBackoffMin
+		}
+		if d, ok := retryAfter(resp.Header); ok && d > t.delay {
+			t.delay = d
+		}
+		if t.delay > jiraBackoffMax {
+			t.delay = jiraBackoffMax
+		}
+		delay = t.delay
+		t.mu.Unlock()
+
+		if attempt >= rateLimitMaxRetries {
+			return resp, nil
+		}
+		retry, ok := rewindRequest(req)
+		if !ok {
+			return resp, nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"url":     req.URL.String(),
+			"attempt": attempt + 1,
+		}).Warnf("JIRA rate limit exceeded, back off %v", delay)
+		discardResponse(resp)
+		req = retry
+	}
+}
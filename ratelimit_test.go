@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGithubRateLimitTransportPause(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{"reset", map[string]string{"X-RateLimit-Reset": strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)}, 10 * time.Minute},
+		{"no reset", nil, githubRateLimitMinBackoff},
+		{"invalid reset", map[string]string{"X-RateLimit-Reset": "soon"}, githubRateLimitMinBackoff},
+		{"no reset but Retry-After", map[string]string{"Retry-After": "120"}, 2 * time.Minute},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			for k, v := range test.header {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		transport := &githubRateLimitTransport{}
+		// the retry waits for the pause, which outlives the request
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		start := time.Now()
+		_, err := transport.RoundTrip(req.WithContext(ctx))
+		cancel()
+		server.Close()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: RoundTrip() error = %v, want waiting for rate limit reset", test.name, err)
+		}
+		if got := transport.resumeAt.Sub(start); got < test.want-5*time.Second || got > test.want+5*time.Second {
+			t.Errorf("%s: paused %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	githubGoogle "github.com/google/go-github/github"
+	"github.com/juju/errors"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// reconcile report formats
+const (
+	reconcileFormatText = "text"
+	reconcileFormatJSON = "json"
+)
+
+// reGithubIssueLink matches GitHub issue links in JIRA issues, which tell the
+// repo of JIRA issues whose GitHub issue is not listed
+var reGithubIssueLink = regexp.MustCompile(`https?://[^/\s|\]]+/([^/\s|\]]+)/([^/\s|\]]+)/issues/(\d+)`)
+
+// driftIssue is a GitHub issue missing in JIRA, or a JIRA issue whose GitHub issue is gone
+type driftIssue struct {
+	GithubNumber int    `json:"github-number,omitempty"`
+	GithubURL    string `json:"github-url,omitempty"`
+	JiraKey      string `json:"jira-key,omitempty"`
+}
+
+// driftMismatch is a field which differs between GitHub issue and its JIRA issue
+type driftMismatch struct {
+	GithubNumber int    `json:"github-number"`
+	JiraKey      string `json:"jira-key"`
+	Field        string `json:"field"`
+	Github       string `json:"github"`
+	Jira         string `json:"jira"`
+}
+
+// driftDuplicate is a GitHub issue mapped by more than one JIRA issue, the
+// oldest one is canonical and the others are merged into it by sync
+type driftDuplicate struct {
+	GithubNumber int      `json:"github-number,omitempty"`
+	GithubID     int64    `json:"github-id"`
+	Canonical    string   `json:"canonical"`
+	Duplicates   []string `json:"duplicates"`
+}
+
+// repoDriftReport is the drift between a GitHub repo and its JIRA project
+type repoDriftReport struct {
+	Repo        string `json:"repo"`
+	JiraTarget  string `json:"jira-target,omitempty"`
+	JiraProject string `json:"jira-project"`
+
+	Missing    []driftIssue     `json:"missing"`
+	Orphans    []driftIssue     `json:"orphans"`
+	Mismatches []driftMismatch  `json:"mismatches"`
+	Duplicates []driftDuplicate `json:"duplicates"`
+}
+
+func (report *repoDriftReport) drift() int {
+	return len(report.Missing) + len(report.Orphans) + len(report.Mismatches) + len(report.Duplicates)
+}
+
+func newReconcileCommand() *command {
+	var repos repoList
+	var since sinceTime
+	var format string
+	return &command{
+		name:  "reconcile",
+		usage: "report drift between GitHub issues and JIRA issues without changing anything",
+		setFlags: func(fs *flag.FlagSet) {
+			fs.Var(&repos, "repo", "comma separated repos to reconcile, owner/name or endpoint:owner/name, all repos if not given")
+			fs.Var(&since, "since", "reconcile issues updated since RFC3339 time or duration before now, e.g. 72h, all issues if not given")
+			fs.StringVar(&format, "format", reconcileFormatText, "report format: text, json")
+		},
+		run: func(config *Config) int {
+			if format != reconcileFormatText && format != reconcileFormatJSON {
+				fmt.Fprintf(os.Stderr, "'%s' is an invalid report format\n", format)
+				return exitUsage
+			}
+			// the whole history is compared unless since is given
+			config.GithubIssueSince = since.Time
+			config.UseSyncCursors = false
+
+			server, err := newServer(config)
+			if err != nil {
+				logrus.WithError(err).Error("Error creating server")
+				return exitFailure
+			}
+
+			l := logrus.WithField("event-type", "reconcile")
+			reports, err := server.reconcile(l, repos)
+			if err != nil {
+				logrus.WithError(err).Error("reconcile failed")
+				return exitFailure
+			}
+			if err := writeReconcileReport(os.Stdout, format, reports); err != nil {
+				logrus.WithError(err).Error("Error writing reconcile report")
+				return exitFailure
+			}
+			for _, report := range reports {
+				if report.drift() != 0 {
+					return exitDrift
+				}
+			}
+			return exitOK
+		},
+	}
+}
+
+// reconcile compares GitHub issues of repos with JIRA issues mapped by GitHub ID
+// and reports the drift per repo, nothing is changed
+func (s *Server) reconcile(l *logrus.Entry, repos []string) ([]*repoDriftReport, error) {
+	repoConfigs, err := s.syncRepoConfigs(l, repos)
+	if err != nil {
+		return nil, err
+	}
+
+	// JIRA issues are searched once per project, as repos could share projects
+	projectIssues := map[string][]jira.Issue{}
+	var reports []*repoDriftReport
+	for _, repoConfig := range repoConfigs {
+		l := l.WithField("repo", repoConfig.fullName())
+
+		project := repoConfig.JiraTarget + ":" + repoConfig.JiraProjectKey
+		jiraIssues, ok := projectIssues[project]
+		if !ok {
+			jiraIssues, err = s.getJiraIssuesByProject(l, repoConfig)
+			if err != nil {
+				return nil, errors.Annotatef(err, "search JIRA project %s", repoConfig.JiraProjectKey)
+			}
+			projectIssues[project] = jiraIssues
+		}
+
+		report, err := s.reconcileRepo(l, repoConfig, jiraIssues)
+		if err != nil {
+			return nil, errors.Annotatef(err, "reconcile repo %s", repoConfig.fullName())
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// getJiraIssuesByProject searches all JIRA issues having GitHub ID in the project of repo
+func (s *Server) getJiraIssuesByProject(l *logrus.Entry, repoConfig RepoConfig) ([]jira.Issue, error) {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+	githubIssueFieldKey, err := jiraTarget.getFieldKey(gitHubID)
+	if err != nil {
+		return nil, err
+	}
+	jql := fmt.Sprintf("project='%s' AND cf[%s] is not EMPTY ORDER BY key",
+		repoConfig.JiraProjectKey, githubIssueFieldKey)
+
+	var allIssues []jira.Issue
+	options := &jira.SearchOptions{StartAt: 0, MaxResults: 100}
+	for {
+		l.Debugf("search JIRA issues from %d in %s", options.StartAt, repoConfig.JiraProjectKey)
+		issues, resp, err := jiraTarget.issueService.Search(jql, options)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		allIssues = append(allIssues, issues...)
+		options.StartAt += len(issues)
+		if len(issues) == 0 || options.StartAt >= resp.Total {
+			break
+		}
+	}
+	return allIssues, nil
+}
+
+// jiraIssueGithubID returns the GitHub ID of JIRA issue
+func (target *jiraTarget) jiraIssueGithubID(jiraIssue jira.Issue) (int64, bool) {
+	githubIssueFieldID, err := target.getFieldID(gitHubID)
+	if err != nil || jiraIssue.Fields == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(jiraFieldValue(jiraIssue.Fields.Unknowns[githubIssueFieldID]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *Server) reconcileRepo(l *logrus.Entry, repoConfig RepoConfig, jiraIssues []jira.Issue) (*repoDriftReport, error) {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+	report := &repoDriftReport{
+		Repo:        repoConfig.fullName(),
+		JiraTarget:  repoConfig.JiraTarget,
+		JiraProject: repoConfig.JiraProjectKey,
+	}
+
+	githubIssues, err := s.getGithubIssuesByRepo(l, repoConfig, s.Config.GithubIssueSince)
+	if err != nil {
+		return nil, err
+	}
+
+	byGithubID := map[int64][]jira.Issue{}
+	for _, jiraIssue := range jiraIssues {
+		if id, ok := jiraTarget.jiraIssueGithubID(jiraIssue); ok {
+			byGithubID[id] = append(byGithubID[id], jiraIssue)
+		}
+	}
+
+	listed := map[int64]bool{}
+	for _, githubIssue := range githubIssues {
+		listed[githubIssue.GetID()] = true
+
+		mapped := byGithubID[githubIssue.GetID()]
+		if len(mapped) == 0 {
+			if repoConfig.issueFilter().match(githubIssue.Issue, githubIssue.AuthorAssociation) == nil {
+				report.Missing = append(report.Missing, driftIssue{
+					GithubNumber: githubIssue.GetNumber(),
+					GithubURL:    githubIssue.GetHTMLURL(),
+				})
+			}
+			continue
+		}
+		canonical, duplicates := pickCanonicalIssue(mapped)
+		if len(duplicates) != 0 {
+			var keys []string
+			for _, jiraIssue := range duplicates {
+				keys = append(keys, jiraIssue.Key)
+			}
+			report.Duplicates = append(report.Duplicates, driftDuplicate{
+				GithubNumber: githubIssue.GetNumber(),
+				GithubID:     githubIssue.GetID(),
+				Canonical:    canonical.Key,
+				Duplicates:   keys,
+			})
+		}
+
+		mismatches, err := s.compareIssue(repoConfig, canonical, githubIssue.Issue)
+		if err != nil {
+			return nil, errors.Annotatef(err, "compare %s with %s", githubIssue.GetHTMLURL(), canonical.Key)
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+	}
+
+	// JIRA issues not listed belong to this repo if they link to it, they are
+	// orphans if their GitHub issue is gone, i.e. deleted or transferred
+	githubClient := s.githubClientOf(repoConfig.GithubEndpoint)
+	for id, mapped := range byGithubID {
+		if listed[id] {
+			continue
+		}
+		number, ok := repoConfig.linkedIssueNumber(mapped[0])
+		if !ok {
+			continue
+		}
+		githubIssue, _, err := githubClient.Issues.Get(context.Background(), repoConfig.GithubOwner, repoConfig.name, number)
+		if err != nil && !isGithubNotFound(err) {
+			return nil, err
+		}
+		if err == nil && githubIssue.GetID() == id {
+			// updated before since, or a pull request
+			continue
+		}
+		for _, jiraIssue := range mapped {
+			report.Orphans = append(report.Orphans, driftIssue{
+				GithubNumber: number,
+				JiraKey:      jiraIssue.Key,
+			})
+		}
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool {
+		return report.Orphans[i].JiraKey < report.Orphans[j].JiraKey
+	})
+
+	return report, nil
+}
+
+// linkedIssueNumber finds the number of GitHub issue of repo linked by JIRA issue,
+// in the GitHub URL field, or the last link in description which is the footer
+func (repoConfig RepoConfig) linkedIssueNumber(jiraIssue jira.Issue) (int, bool) {
+	if jiraIssue.Fields == nil {
+		return 0, false
+	}
+	var link []string
+	for _, v := range jiraIssue.Fields.Unknowns {
+		if s, ok := v.(string); ok {
+			if matches := reGithubIssueLink.FindStringSubmatch(s); matches != nil {
+				link = matches
+			}
+		}
+	}
+	if link == nil {
+		all := reGithubIssueLink.FindAllStringSubmatch(jiraIssue.Fields.Description, -1)
+		if len(all) == 0 {
+			return 0, false
+		}
+		link = all[len(all)-1]
+	}
+
+	if !strings.EqualFold(link[1], repoConfig.GithubOwner) || !strings.EqualFold(link[2], repoConfig.name) {
+		return 0, false
+	}
+	number, err := strconv.Atoi(link[3])
+	if err != nil {
+		return 0, false
+	}
+	return number, true
+}
+
+// isGithubNotFound reports whether GitHub API error means the resource is gone
+func isGithubNotFound(err error) bool {
+	if errResp, ok := err.(*githubGoogle.ErrorResponse); ok && errResp.Response != nil {
+		return errResp.Response.StatusCode == http.StatusNotFound || errResp.Response.StatusCode == http.StatusGone
+	}
+	return false
+}
+
+// compareIssue compares the fields synchronized by presync between GitHub issue
+// and its JIRA issue
+func (s *Server) compareIssue(repoConfig RepoConfig, jiraIssue jira.Issue, githubIssue githubGoogle.Issue) ([]driftMismatch, error) {
+	jiraTarget := s.jiraTargetOf(repoConfig)
+	fields := jiraIssue.Fields
+	if fields == nil {
+		fields = &jira.IssueFields{}
+	}
+
+	var mismatches []driftMismatch
+	mismatch := func(field, githubValue, jiraValue string) {
+		mismatches = append(mismatches, driftMismatch{
+			GithubNumber: githubIssue.GetNumber(),
+			JiraKey:      jiraIssue.Key,
+			Field:        field,
+			Github:       githubValue,
+			Jira:         jiraValue,
+		})
+	}
+
+	desired := s.desiredJiraIssueState(githubIssue, repoConfig)
+	if desired.summary != fields.Summary {
+		mismatch("summary", desired.summary, fields.Summary)
+	}
+	if strings.TrimSpace(desired.description) != strings.TrimSpace(fields.Description) {
+		mismatch("description", desired.description, fields.Description)
+	}
+
+	jiraStatus := ""
+	if fields.Status != nil {
+		jiraStatus = fields.Status.Name
+	}
+	if desired.done != jiraIssueDone(jiraIssue) {
+		mismatch("state", githubIssue.GetState(), jiraStatus)
+	}
+
+	differs, err := jiraTarget.assigneeDiffers(jiraIssue, desired.assignee)
+	if err != nil {
+		return nil, err
+	}
+	if differs {
+		githubAssignee, jiraAssignee := "", ""
+		if desired.assignee != nil {
+			githubAssignee = desired.assignee.Name
+		}
+		if fields.Assignee != nil {
+			jiraAssignee = fields.Assignee.Name
+			if jiraAssignee == "" {
+				jiraAssignee = fields.Assignee.DisplayName
+			}
+		}
+		mismatch("assignee", githubAssignee, jiraAssignee)
+	}
+
+	if len(desired.issueTypes) != 0 && !containsString(desired.issueTypes, fields.Type.Name) {
+		mismatch("type", strings.Join(desired.issueTypes, "|"), fields.Type.Name)
+	}
+	jiraComponents := jiraIssueComponents(jiraIssue)
+	if !sameStrings(desired.components, jiraComponents) {
+		mismatch("components", strings.Join(desired.components, ","), strings.Join(jiraComponents, ","))
+	}
+
+	githubMissing, jiraOrphan, err := s.compareComments(repoConfig, jiraIssue, githubIssue)
+	if err != nil {
+		return nil, err
+	}
+	if len(githubMissing) != 0 || len(jiraOrphan) != 0 {
+		mismatch("comments", "missing in JIRA: "+joinIDs(githubMissing), "deleted on GitHub: "+joinIDs(jiraOrphan))
+	}
+
+	return mismatches, nil
+}
+
+// compareComments returns IDs of GitHub comments missing in JIRA issue, and
+// GitHub comment IDs of JIRA comments whose GitHub comment is deleted
+func (s *Server) compareComments(repoConfig RepoConfig, jiraIssue jira.Issue, githubIssue githubGoogle.Issue) ([]int64, []int64, error) {
+	jiraComments, err := s.jiraTargetOf(repoConfig).getIssueComments(jiraIssue.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	synced := map[int64]bool{}
+	for _, jiraComment := range jiraComments.Comments {
+		if id, ok := githubCommentID(jiraComment.Body); ok {
+			synced[id] = true
+		}
+	}
+
+	// GitHub comments are only listed when their number differs from the
+	// synchronized ones, a deleted comment replaced by a new one is not told
+	if githubIssue.GetComments() == len(synced) {
+		return nil, nil, nil
+	}
+	var githubComments []*githubGoogle.IssueComment
+	if githubIssue.GetComments() != 0 {
+		githubComments, err = s.getGithubIssueComments(repoConfig, githubIssue.GetNumber(), time.Time{})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var missing, orphan []int64
+	for _, githubComment := range githubComments {
+		if !synced[githubComment.GetID()] {
+			missing = append(missing, githubComment.GetID())
+		}
+		delete(synced, githubComment.GetID())
+	}
+	for id := range synced {
+		orphan = append(orphan, id)
+	}
+	sort.Slice(orphan, func(i, j int) bool { return orphan[i] < orphan[j] })
+	return missing, orphan, nil
+}
+
+func joinIDs(ids []int64) string {
+	var ret []string
+	for _, id := range ids {
+		ret = append(ret, strconv.FormatInt(id, 10))
+	}
+	if len(ret) == 0 {
+		return "none"
+	}
+	return strings.Join(ret, ",")
+}
+
+// writeReconcileReport writes drift reports as JSON or human-readable tables
+func writeReconcileReport(w io.Writer, format string, reports []*repoDriftReport) error {
+	if format == reconcileFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+
+	var missing, orphans, mismatches, duplicates int
+	for _, report := range reports {
+		missing += len(report.Missing)
+		orphans += len(report.Orphans)
+		mismatches += len(report.Mismatches)
+		duplicates += len(report.Duplicates)
+
+		project := report.JiraProject
+		if report.JiraTarget != "" {
+			project = report.JiraTarget + ":" + project
+		}
+		fmt.Fprintf(w, "== %s -> %s\n", report.Repo, project)
+		if report.drift() == 0 {
+			fmt.Fprintf(w, "in sync\n\n")
+			continue
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		if len(report.Missing) != 0 {
+			fmt.Fprintf(tw, "\nmissing in JIRA\nGITHUB\tURL\n")
+			for _, issue := range report.Missing {
+				fmt.Fprintf(tw, "#%d\t%s\n", issue.GithubNumber, issue.GithubURL)
+			}
+		}
+		if len(report.Orphans) != 0 {
+			fmt.Fprintf(tw, "\norphans, GitHub issue gone\nJIRA\tGITHUB\n")
+			for _, issue := range report.Orphans {
+				fmt.Fprintf(tw, "%s\t#%d\n", issue.JiraKey, issue.GithubNumber)
+			}
+		}
+		if len(report.Mismatches) != 0 {
+			fmt.Fprintf(tw, "\nmismatches\nGITHUB\tJIRA\tFIELD\tGITHUB VALUE\tJIRA VALUE\n")
+			for _, m := range report.Mismatches {
+				fmt.Fprintf(tw, "#%d\t%s\t%s\t%s\t%s\n", m.GithubNumber, m.JiraKey, m.Field, oneLine(m.Github), oneLine(m.Jira))
+			}
+		}
+		if len(report.Duplicates) != 0 {
+			fmt.Fprintf(tw, "\nduplicates\nGITHUB\tGITHUB ID\tCANONICAL\tDUPLICATES\n")
+			for _, d := range report.Duplicates {
+				fmt.Fprintf(tw, "#%d\t%d\t%s\t%s\n", d.GithubNumber, d.GithubID, d.Canonical, strings.Join(d.Duplicates, ","))
+			}
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "%d repos, %d missing, %d orphans, %d mismatches, %d duplicates\n",
+		len(reports), missing, orphans, mismatches, duplicates)
+	return nil
+}
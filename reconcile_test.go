@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/Tom-Xie/go-jira"
+	githubGoogle "github.com/google/go-github/github"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// fakeReconcileBackend serves GitHub issues and comments of repo o/r, and JIRA
+// comments of issues by ID
+type fakeReconcileBackend struct {
+	// listed issues, and issues got by number which are not listed
+	listed []*githubRepoIssue
+	got    map[int]*githubRepoIssue
+	// GitHub comment IDs by issue number
+	githubComments map[int][]int64
+	// JIRA comment bodies by JIRA issue ID
+	jiraComments map[string][]string
+	// GitHub issues whose comments are listed
+	commentsListed []int
+}
+
+func (b *fakeReconcileBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/repos/o/r/issues":
+		json.NewEncoder(w).Encode(b.listed)
+	case len(parts) == 5 && parts[3] == "issues":
+		number, _ := strconv.Atoi(parts[4])
+		issue, ok := b.got[number]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(issue)
+	case len(parts) == 6 && parts[3] == "issues" && parts[5] == "comments":
+		number, _ := strconv.Atoi(parts[4])
+		b.commentsListed = append(b.commentsListed, number)
+		var comments []*githubGoogle.IssueComment
+		for _, id := range b.githubComments[number] {
+			comments = append(comments, &githubGoogle.IssueComment{ID: githubGoogle.Int64(id)})
+		}
+		json.NewEncoder(w).Encode(comments)
+	case len(parts) == 6 && parts[0] == "rest" && parts[5] == "comment":
+		var comments []*jira.Comment
+		for i, body := range b.jiraComments[parts[4]] {
+			comments = append(comments, &jira.Comment{ID: strconv.Itoa(i), Body: body})
+		}
+		json.NewEncoder(w).Encode(jiraCommentsPage{Total: len(comments), MaxResults: jiraCommentsPageSize, Comments: comments})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newReconcileTestServer returns server talking to backend as both GitHub and JIRA
+func newReconcileTestServer(t *testing.T, backend http.Handler) (*Server, func()) {
+	httpServer := httptest.NewServer(backend)
+	githubClient := githubGoogle.NewClient(nil)
+	githubClient.BaseURL, _ = url.Parse(httpServer.URL + "/")
+	jiraClient, err := jira.NewClient(nil, httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Config:        &Config{Loc: time.UTC},
+		githubClients: map[string]*githubGoogle.Client{"": githubClient},
+		jiraTargets: map[string]*jiraTarget{"": {
+			client:   jiraClient,
+			fieldIDs: map[fieldKey]string{gitHubID: "10000", gitHubURL: "10001"},
+		}},
+	}
+	return s, httpServer.Close
+}
+
+func testGithubIssue(number int, comments int, labels ...string) *githubRepoIssue {
+	issue := &githubRepoIssue{Issue: githubGoogle.Issue{
+		ID:       githubGoogle.Int64(int64(100 + number)),
+		Number:   githubGoogle.Int(number),
+		Title:    githubGoogle.String(fmt.Sprintf("issue %d", number)),
+		State:    githubGoogle.String("open"),
+		HTMLURL:  githubGoogle.String(fmt.Sprintf("https://github.com/o/r/issues/%d", number)),
+		User:     &githubGoogle.User{Login: githubGoogle.String("alice")},
+		Comments: githubGoogle.Int(comments),
+	}}
+	for _, label := range labels {
+		issue.Labels = append(issue.Labels, githubGoogle.Label{Name: githubGoogle.String(label)})
+	}
+	return issue
+}
+
+// testJiraIssue is JIRA issue of GitHub ID linking to the GitHub issue in description
+func testJiraIssue(id string, githubID int64, link string, created int) jira.Issue {
+	return jira.Issue{ID: id, Key: "TEST-" + id, Fields: &jira.IssueFields{
+		Description: "body\n\nCreated from [GitHub|" + link + "]",
+		Created:     jira.Time(time.Date(2018, 10, 1, 0, 0, created, 0, time.UTC)),
+		Unknowns:    map[string]interface{}{"customfield_10000": float64(githubID)},
+	}}
+}
+
+func TestReconcileRepo(t *testing.T) {
+	link := func(number int) string { return fmt.Sprintf("https://github.com/o/r/issues/%d", number) }
+	tests := []struct {
+		name         string
+		listed       []*githubRepoIssue
+		got          map[int]*githubRepoIssue
+		jiraIssues   []jira.Issue
+		jiraComments map[string][]string
+
+		wantMissing    []int
+		wantOrphans    []string
+		wantDuplicates []driftDuplicate
+		// GitHub numbers with drift of synchronized comments
+		wantCommentDrift []int
+	}{
+		{
+			name:        "missing in JIRA",
+			listed:      []*githubRepoIssue{testGithubIssue(1, 0)},
+			wantMissing: []int{1},
+		},
+		{
+			name:   "filtered out is not missing",
+			listed: []*githubRepoIssue{testGithubIssue(2, 0, "type/question")},
+		},
+		{
+			name:       "synchronized",
+			listed:     []*githubRepoIssue{testGithubIssue(3, 0)},
+			jiraIssues: []jira.Issue{testJiraIssue("3", 103, link(3), 0)},
+		},
+		{
+			name:   "duplicates",
+			listed: []*githubRepoIssue{testGithubIssue(4, 0)},
+			jiraIssues: []jira.Issue{
+				testJiraIssue("41", 104, link(4), 2),
+				testJiraIssue("42", 104, link(4), 1),
+				testJiraIssue("43", 104, link(4), 3),
+			},
+			wantDuplicates: []driftDuplicate{{GithubNumber: 4, GithubID: 104, Canonical: "TEST-42", Duplicates: []string{"TEST-41", "TEST-43"}}},
+		},
+		{
+			name:        "orphan of deleted GitHub issue",
+			jiraIssues:  []jira.Issue{testJiraIssue("5", 105, link(5), 0), testJiraIssue("51", 105, link(5), 1)},
+			wantOrphans: []string{"TEST-5", "TEST-51"},
+		},
+		{
+			name:        "orphan of transferred GitHub issue whose number is taken",
+			got:         map[int]*githubRepoIssue{6: testGithubIssue(7, 0)},
+			jiraIssues:  []jira.Issue{testJiraIssue("6", 106, link(6), 0)},
+			wantOrphans: []string{"TEST-6"},
+		},
+		{
+			name:       "GitHub issue not listed but still there",
+			got:        map[int]*githubRepoIssue{8: testGithubIssue(8, 0)},
+			jiraIssues: []jira.Issue{testJiraIssue("8", 108, link(8), 0)},
+		},
+		{
+			name:       "JIRA issue of another repo",
+			jiraIssues: []jira.Issue{testJiraIssue("9", 109, "https://github.com/o/other/issues/9", 0)},
+		},
+		{
+			name:   "JIRA issue without GitHub ID",
+			listed: []*githubRepoIssue{testGithubIssue(10, 0)},
+			jiraIssues: []jira.Issue{{ID: "10", Key: "TEST-10", Fields: &jira.IssueFields{
+				Description: "Created from [GitHub|" + link(10) + "]",
+			}}},
+			wantMissing: []int{10},
+		},
+		{
+			name:             "comment deleted on GitHub",
+			listed:           []*githubRepoIssue{testGithubIssue(11, 0)},
+			jiraIssues:       []jira.Issue{testJiraIssue("11", 111, link(11), 0)},
+			jiraComments:     map[string][]string{"11": {"body\n{anchor:github-comment-9001}"}},
+			wantCommentDrift: []int{11},
+		},
+	}
+	for _, test := range tests {
+		backend := &fakeReconcileBackend{listed: test.listed, got: test.got, jiraComments: test.jiraComments}
+		s, closeServer := newReconcileTestServer(t, backend)
+		filter, err := newIssueFilter(IssueFilterConfig{ExcludeLabels: []string{"type/question"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		repoConfig := RepoConfig{GithubOwner: "o", name: "r", JiraProjectKey: "TEST", filter: filter}
+
+		report, err := s.reconcileRepo(logrus.WithField("test", test.name), repoConfig, test.jiraIssues)
+		closeServer()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		var missing []int
+		for _, issue := range report.Missing {
+			missing = append(missing, issue.GithubNumber)
+		}
+		var orphans []string
+		for _, issue := range report.Orphans {
+			orphans = append(orphans, issue.JiraKey)
+		}
+		var commentDrift []int
+		for _, mismatch := range report.Mismatches {
+			if mismatch.Field == "comments" {
+				commentDrift = append(commentDrift, mismatch.GithubNumber)
+			}
+		}
+		if !reflect.DeepEqual(missing, test.wantMissing) {
+			t.Errorf("%s: missing = %v, want %v", test.name, missing, test.wantMissing)
+		}
+		if !reflect.DeepEqual(orphans, test.wantOrphans) {
+			t.Errorf("%s: orphans = %v, want %v", test.name, orphans, test.wantOrphans)
+		}
+		if !reflect.DeepEqual(report.Duplicates, test.wantDuplicates) {
+			t.Errorf("%s: duplicates = %+v, want %+v", test.name, report.Duplicates, test.wantDuplicates)
+		}
+		if !reflect.DeepEqual(commentDrift, test.wantCommentDrift) {
+			t.Errorf("%s: comment drift = %v, want %v", test.name, commentDrift, test.wantCommentDrift)
+		}
+	}
+}
+
+func TestCompareComments(t *testing.T) {
+	footer := func(id int64) string {
+		return fmt.Sprintf("Comment [(ID %d)|https://github.com/o/r/issues/1#issuecomment-%d] from GitHub user [foo|https://github.com/foo]\n\n----\n\nbody", id, id)
+	}
+	anchor := func(id int64) string { return fmt.Sprintf("body\n{anchor:github-comment-%d}", id) }
+	tests := []struct {
+		name           string
+		jiraComments   []string
+		githubCount    int
+		githubComments []int64
+		wantMissing    []int64
+		wantOrphan     []int64
+		wantListed     bool
+	}{
+		{
+			name:         "same number of comments not listed",
+			jiraComments: []string{footer(1), anchor(2)},
+			githubCount:  2,
+		},
+		{
+			name:         "comments written in JIRA not counted",
+			jiraComments: []string{"written in JIRA", footer(1)},
+			githubCount:  1,
+		},
+		{
+			name:         "deleted comment replaced by a new one not told",
+			jiraComments: []string{footer(1), footer(2)},
+			githubCount:  2,
+		},
+		{
+			name:           "missing in JIRA",
+			jiraComments:   []string{footer(1)},
+			githubCount:    3,
+			githubComments: []int64{1, 2, 3},
+			wantMissing:    []int64{2, 3},
+			wantListed:     true,
+		},
+		{
+			name:           "deleted on GitHub",
+			jiraComments:   []string{anchor(3), footer(1), footer(2)},
+			githubCount:    1,
+			githubComments: []int64{2},
+			wantOrphan:     []int64{1, 3},
+			wantListed:     true,
+		},
+		{
+			name:         "all deleted on GitHub",
+			jiraComments: []string{footer(1)},
+			githubCount:  0,
+			wantOrphan:   []int64{1},
+		},
+		{
+			name:           "missing and deleted",
+			jiraComments:   []string{footer(1)},
+			githubCount:    2,
+			githubComments: []int64{2, 3},
+			wantMissing:    []int64{2, 3},
+			wantOrphan:     []int64{1},
+			wantListed:     true,
+		},
+	}
+	for _, test := range tests {
+		backend := &fakeReconcileBackend{
+			githubComments: map[int][]int64{1: test.githubComments},
+			jiraComments:   map[string][]string{"10001": test.jiraComments},
+		}
+		s, closeServer := newReconcileTestServer(t, backend)
+		githubIssue := testGithubIssue(1, test.githubCount).Issue
+		repoConfig := RepoConfig{GithubOwner: "o", name: "r"}
+
+		missing, orphan, err := s.compareComments(repoConfig, jira.Issue{ID: "10001"}, githubIssue)
+		closeServer()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(missing, test.wantMissing) || !reflect.DeepEqual(orphan, test.wantOrphan) {
+			t.Errorf("%s: compareComments() = %v, %v, want %v, %v", test.name, missing, orphan, test.wantMissing, test.wantOrphan)
+		}
+		if listed := len(backend.commentsListed) != 0; listed != test.wantListed {
+			t.Errorf("%s: GitHub comments listed = %v, want %v", test.name, listed, test.wantListed)
+		}
+	}
+}
+
+func TestLinkedIssueNumber(t *testing.T) {
+	repoConfig := RepoConfig{GithubOwner: "PingCAP", name: "tidb"}
+	tests := []struct {
+		name       string
+		issue      jira.Issue
+		wantNumber int
+		wantOK     bool
+	}{
+		{
+			name:       "GitHub URL field",
+			issue:      jira.Issue{Fields: &jira.IssueFields{Unknowns: map[string]interface{}{"customfield_10001": "https://github.com/pingcap/tidb/issues/12"}}},
+			wantNumber: 12,
+			wantOK:     true,
+		},
+		{
+			name: "GitHub URL field wins over description",
+			issue: jira.Issue{Fields: &jira.IssueFields{
+				Description: "[#13|https://github.com/pingcap/tidb/issues/13]",
+				Unknowns:    map[string]interface{}{"customfield_10001": "https://github.com/pingcap/tidb/issues/12"},
+			}},
+			wantNumber: 12,
+			wantOK:     true,
+		},
+		{
+			name: "last link in description is the footer",
+			issue: jira.Issue{Fields: &jira.IssueFields{
+				Description: "see [#1|https://github.com/pingcap/tidb/issues/1]\n\nCreate issue [(#14)|https://github.com/pingcap/tidb/issues/14] from GitHub user",
+			}},
+			wantNumber: 14,
+			wantOK:     true,
+		},
+		{
+			name: "GitHub Enterprise link",
+			issue: jira.Issue{Fields: &jira.IssueFields{
+				Description: "Create issue [(#15)|https://github.example.com/pingcap/tidb/issues/15] from GitHub user",
+			}},
+			wantNumber: 15,
+			wantOK:     true,
+		},
+		{
+			name: "footer of another repo",
+			issue: jira.Issue{Fields: &jira.IssueFields{
+				Description: "see [#1|https://github.com/pingcap/tidb/issues/1]\n\nCreate issue [(#16)|https://github.com/pingcap/tikv/issues/16] from GitHub user",
+			}},
+		},
+		{
+			name:  "no link",
+			issue: jira.Issue{Fields: &jira.IssueFields{Description: "written in JIRA"}},
+		},
+		{
+			name: "no fields",
+		},
+	}
+	for _, test := range tests {
+		number, ok := repoConfig.linkedIssueNumber(test.issue)
+		if number != test.wantNumber || ok != test.wantOK {
+			t.Errorf("%s: linkedIssueNumber() = %d, %v, want %d, %v", test.name, number, ok, test.wantNumber, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// webhookDelivery is a GitHub webhook delivery stored for replay
+type webhookDelivery struct {
+	Event      string          `json:"event"`
+	GUID       string          `json:"guid"`
+	Header     http.Header     `json:"header,omitempty"` // GitHub headers only
+	Payload    json.RawMessage `json:"payload"`
+	ReceivedAt time.Time       `json:"received-at"`
+}
+
+// newWebhookDelivery keeps the webhook delivery with GitHub headers only
+func newWebhookDelivery(eventType, eventGUID string, payload []byte, h http.Header) webhookDelivery {
+	delivery := webhookDelivery{
+		Event:      eventType,
+		GUID:       eventGUID,
+		Header:     http.Header{},
+		Payload:    payload,
+		ReceivedAt: time.Now(),
+	}
+	for k, v := range h {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), "X-Github-") {
+			delivery.Header[k] = v
+		}
+	}
+	return delivery
+}
+
+// storeWebhookDelivery saves webhook delivery into dir as a JSON file, file
+// names begin with the received time so that they sort in order
+func storeWebhookDelivery(dir string, delivery webhookDelivery) error {
+	b, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.json", delivery.ReceivedAt.UTC().Format("20060102T150405.000000000"), delivery.GUID)
+	return ioutil.WriteFile(filepath.Join(dir, name), b, 0600)
+}
+
+// loadWebhookDeliveries reads stored deliveries from files, or JSON files in
+// directories, in the order of file names
+func loadWebhookDeliveries(paths []string) ([]webhookDelivery, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	var deliveries []webhookDelivery
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var delivery webhookDelivery
+		if err := json.Unmarshal(b, &delivery); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if delivery.Event == "" || len(delivery.Payload) == 0 {
+			return nil, fmt.Errorf("%s: not a webhook delivery", file)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// replayWebhookDeliveries feeds deliveries through demuxEvent in order until
+// shutdown, and returns the number of replayed and failed ones
+func (s *Server) replayWebhookDeliveries(deliveries []webhookDelivery) (replayed, failed int) {
+	for _, delivery := range deliveries {
+		// deliveries not replayed yet are left on shutdown
+		if s.stopping() {
+			break
+		}
+		replayed++
+		l := logrus.WithFields(logrus.Fields{
+			"event-type": delivery.Event,
+			"event-GUID": delivery.GUID,
+		})
+		l.Info("replay event")
+		if err := s.demuxEvent(delivery.Event, delivery.GUID, delivery.Payload, delivery.Header); err != nil {
+			failed++
+		}
+	}
+	return replayed, failed
+}
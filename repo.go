@@ -0,0 +1,248 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// fullName returns GitHub repo full name "owner/name"
+func (repoConfig RepoConfig) fullName() string {
+	return repoConfig.GithubOwner + "/" + repoConfig.name
+}
+
+// jiraTemplates returns the parsed templates of repo
+func (repoConfig RepoConfig) jiraTemplates() *jiraTemplates {
+	if repoConfig.templates == nil {
+		return defaultJiraTemplates
+	}
+	return repoConfig.templates
+}
+
+// issueFilter returns the compiled issue filter of repo
+func (repoConfig RepoConfig) issueFilter() *issueFilter {
+	if repoConfig.filter == nil {
+		return defaultIssueFilter
+	}
+	return repoConfig.filter
+}
+
+// matchAny reports whether repo is one of "owner/name" repos, repos of other
+// GitHub endpoints are given as "endpoint:owner/name"
+func (repoConfig RepoConfig) matchAny(repos []string) bool {
+	key := repoConfigKey(repoConfig.GithubEndpoint, repoConfig.GithubOwner, repoConfig.name)
+	for _, repo := range repos {
+		endpoint := ""
+		if i := strings.Index(repo, ":"); i >= 0 {
+			endpoint, repo = repo[:i], repo[i+1:]
+		}
+		if i := strings.Index(repo, "/"); i >= 0 && repoConfigKey(endpoint, repo[:i], repo[i+1:]) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// repoConfigKey is the key of RepoConfigMap, GitHub owner and repo names are case
+// insensitive, repos of other GitHub endpoints are prefixed by the endpoint name
+func repoConfigKey(endpoint, owner, name string) string {
+	key := strings.ToLower(owner + "/" + name)
+	if endpoint != "" {
+		key = endpoint + ":" + key
+	}
+	return key
+}
+
+// normalizeRepoConfigMap re-keys RepoConfigMap by "owner/name", or
+// "endpoint:owner/name" for other GitHub endpoints, and prepares each repo
+// config. Old config keyed by bare repo name takes the owner from
+// `github-owner`, new config could omit `github-owner` as it is in the key.
+// The endpoint could be given in the key as well, so that the same repo name
+// on two GitHub endpoints is configured by two keys
+func (config *Config) normalizeRepoConfigMap() error {
+	repoConfigMap := make(map[string]RepoConfig, len(config.RepoConfigMap))
+	for key, repoConfig := range config.RepoConfigMap {
+		owner, name := "", key
+		if i := strings.Index(key, ":"); i >= 0 {
+			endpoint := key[:i]
+			if repoConfig.GithubEndpoint != "" && repoConfig.GithubEndpoint != endpoint {
+				return errors.Errorf("github-endpoint %s of repo %s mismatches", repoConfig.GithubEndpoint, key)
+			}
+			repoConfig.GithubEndpoint, name = endpoint, key[i+1:]
+		}
+		if i := strings.Index(name, "/"); i >= 0 {
+			owner, name = name[:i], name[i+1:]
+		}
+
+		switch {
+		case owner == "" && repoConfig.GithubOwner == "":
+			return errors.Errorf("github-owner of repo %s should be given", key)
+		case owner == "":
+			logrus.Warnf("repo config [repo.%s] is deprecated, use [repo.\"%s/%s\"] instead", key, repoConfig.GithubOwner, key)
+			owner = repoConfig.GithubOwner
+		case repoConfig.GithubOwner != "" && !strings.EqualFold(repoConfig.GithubOwner, owner):
+			return errors.Errorf("github-owner %s of repo %s mismatches", repoConfig.GithubOwner, key)
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return errors.Errorf("'%s' is an invalid GitHub repo", key)
+		}
+		repoConfig.GithubOwner, repoConfig.name = owner, name
+
+		if err := config.prepareRepoConfig(&repoConfig); err != nil {
+			return errors.Annotatef(err, "repo %s", key)
+		}
+
+		key = repoConfigKey(repoConfig.GithubEndpoint, owner, name)
+		if _, ok := repoConfigMap[key]; ok {
+			return errors.Errorf("repo %s is configured more than once", key)
+		}
+		repoConfigMap[key] = repoConfig
+	}
+	config.RepoConfigMap = repoConfigMap
+
+	return nil
+}
+
+// prepareRepoConfig loads timezone, parses templates, filter and schedule of repo config
+func (config *Config) prepareRepoConfig(repoConfig *RepoConfig) error {
+	if err := config.checkRepoGithubEndpoint(*repoConfig); err != nil {
+		return errors.Trace(err)
+	}
+	if err := config.checkRepoJiraTarget(*repoConfig); err != nil {
+		return errors.Trace(err)
+	}
+
+	var err error
+	if repoConfig.Timezone != "" {
+		repoConfig.loc, err = time.LoadLocation(repoConfig.Timezone)
+		if err != nil {
+			return errors.Annotate(err, "load timezone")
+		}
+	}
+
+	repoConfig.templates, err = parseJiraTemplates(*repoConfig)
+	if err != nil {
+		return errors.Annotate(err, "parse templates")
+	}
+
+	repoConfig.filter, err = newIssueFilter(repoConfig.Filter)
+	if err != nil {
+		return errors.Annotate(err, "parse filter")
+	}
+
+	if repoConfig.SyncSchedule != "" {
+		repoConfig.schedule, err = parseSchedule(repoConfig.SyncSchedule)
+		if err != nil {
+			return errors.Annotate(err, "parse sync-schedule")
+		}
+	}
+
+	return nil
+}
+
+// normalizeRoutes validates routing rules and prepares their repo config
+func (config *Config) normalizeRoutes() error {
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.GithubOwner == "" {
+			return errors.Errorf("github-owner of route %d should be given", i)
+		}
+		if _, err := path.Match(route.RepoPattern, ""); err != nil {
+			return errors.Annotatef(err, "repo-pattern '%s' of route %d", route.RepoPattern, i)
+		}
+		if err := config.prepareRepoConfig(&route.RepoConfig); err != nil {
+			return errors.Annotatef(err, "route %d", i)
+		}
+	}
+	return nil
+}
+
+// match reports whether GitHub repo is routed by the rule
+func (route RouteConfig) match(endpoint, owner, name string) bool {
+	if route.GithubEndpoint != endpoint || !strings.EqualFold(route.GithubOwner, owner) {
+		return false
+	}
+	if route.RepoPattern == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(route.RepoPattern), strings.ToLower(name))
+	return ok
+}
+
+// repoConfig returns the config of GitHub repo routed by the rule
+func (route RouteConfig) repoConfig(owner, name string) RepoConfig {
+	repoConfig := route.RepoConfig
+	repoConfig.GithubOwner, repoConfig.name = owner, name
+	if route.ComponentFromRepo {
+		repoConfig.JiraComponents = []string{name}
+	}
+	return repoConfig
+}
+
+// routeOwners returns GitHub owners of routing rules of endpoint without duplicates
+func (config *Config) routeOwners(endpoint string) []string {
+	var owners []string
+	seen := map[string]bool{}
+	for _, route := range config.Routes {
+		key := strings.ToLower(route.GithubOwner)
+		if route.GithubEndpoint == endpoint && !seen[key] {
+			seen[key] = true
+			owners = append(owners, route.GithubOwner)
+		}
+	}
+	return owners
+}
+
+// hasSyncSchedule reports whether any repo is synchronized periodically, by the
+// global schedule or its own
+func (config *Config) hasSyncSchedule() bool {
+	if config.SyncSchedule != "" {
+		return true
+	}
+	for _, repoConfig := range config.RepoConfigMap {
+		if repoConfig.SyncSchedule != "" {
+			return true
+		}
+	}
+	for _, route := range config.Routes {
+		if route.SyncSchedule != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// githubOwners returns GitHub owners of repos and routing rules of endpoint without duplicates
+func (config *Config) githubOwners(endpoint string) []string {
+	owners := config.routeOwners(endpoint)
+	seen := map[string]bool{}
+	for _, owner := range owners {
+		seen[strings.ToLower(owner)] = true
+	}
+	for _, repoConfig := range config.RepoConfigMap {
+		key := strings.ToLower(repoConfig.GithubOwner)
+		if repoConfig.GithubEndpoint == endpoint && !seen[key] {
+			seen[key] = true
+			owners = append(owners, repoConfig.GithubOwner)
+		}
+	}
+	return owners
+}
+
+// getRepoConfig finds the config of GitHub repo by endpoint, owner and name,
+// explicit repo config overrides routing rules, repos neither configured nor
+// routed are not synchronized
+func (config *Config) getRepoConfig(endpoint, owner, name string) (RepoConfig, bool) {
+	if repoConfig, ok := config.RepoConfigMap[repoConfigKey(endpoint, owner, name)]; ok {
+		return repoConfig, true
+	}
+	for _, route := range config.Routes {
+		if route.match(endpoint, owner, name) {
+			return route.repoConfig(owner, name), true
+		}
+	}
+	return RepoConfig{}, false
+}
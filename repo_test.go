@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRepoConfigMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		repos   map[string]RepoConfig
+		want    map[string]string // key to "endpoint jira-target owner/name"
+		wantErr string
+	}{
+		{
+			name:  "owner/name",
+			repos: map[string]RepoConfig{"PingCAP/TiDB": {}},
+			want:  map[string]string{"pingcap/tidb": "  PingCAP/TiDB"},
+		},
+		{
+			name:  "bare name with github-owner",
+			repos: map[string]RepoConfig{"tidb": {GithubOwner: "pingcap"}},
+			want:  map[string]string{"pingcap/tidb": "  pingcap/tidb"},
+		},
+		{
+			name: "same repo on two endpoints",
+			repos: map[string]RepoConfig{
+				"pingcap/tidb":     {},
+				"ghe:pingcap/tidb": {JiraTarget: "infra"},
+				"pingcap/tikv":     {GithubEndpoint: "ghe"},
+			},
+			want: map[string]string{
+				"pingcap/tidb":     "  pingcap/tidb",
+				"ghe:pingcap/tidb": "ghe infra pingcap/tidb",
+				"ghe:pingcap/tikv": "ghe  pingcap/tikv",
+			},
+		},
+		{
+			name:  "endpoint in key and github-endpoint",
+			repos: map[string]RepoConfig{"ghe:pingcap/tidb": {GithubEndpoint: "ghe"}},
+			want:  map[string]string{"ghe:pingcap/tidb": "ghe  pingcap/tidb"},
+		},
+		{
+			name:    "endpoint in key mismatches github-endpoint",
+			repos:   map[string]RepoConfig{"ghe:pingcap/tidb": {GithubEndpoint: "other"}},
+			wantErr: "github-endpoint other of repo ghe:pingcap/tidb mismatches",
+		},
+		{
+			name:    "unknown endpoint in key",
+			repos:   map[string]RepoConfig{"other:pingcap/tidb": {}},
+			wantErr: "GitHub endpoint other not exists",
+		},
+		{
+			name: "same repo configured twice",
+			repos: map[string]RepoConfig{
+				"pingcap/tidb": {},
+				"PingCAP/tidb": {JiraTarget: "infra"},
+			},
+			wantErr: "repo pingcap/tidb is configured more than once",
+		},
+		{
+			name: "same repo on the same endpoint configured twice",
+			repos: map[string]RepoConfig{
+				"ghe:pingcap/tidb": {},
+				"pingcap/tidb":     {GithubEndpoint: "ghe", JiraTarget: "infra"},
+			},
+			wantErr: "repo ghe:pingcap/tidb is configured more than once",
+		},
+		{
+			name:    "bare name without github-owner",
+			repos:   map[string]RepoConfig{"tidb": {}},
+			wantErr: "github-owner of repo tidb should be given",
+		},
+		{
+			name:    "invalid sync-schedule",
+			repos:   map[string]RepoConfig{"pingcap/tidb": {SyncSchedule: "every hour"}},
+			wantErr: "parse sync-schedule",
+		},
+		{
+			name:    "github-owner mismatches",
+			repos:   map[string]RepoConfig{"pingcap/tidb": {GithubOwner: "tikv"}},
+			wantErr: "github-owner tikv of repo pingcap/tidb mismatches",
+		},
+	}
+	for _, test := range tests {
+		config := &Config{
+			GithubEndpoints: map[string]GithubEndpointConfig{"ghe": {BaseURL: "https://github.example.com/api/v3/"}},
+			JiraTargets:     map[string]JiraTargetConfig{"infra": {BaseURL: "https://jira.example.com"}},
+			RepoConfigMap:   test.repos,
+		}
+		err := config.normalizeRepoConfigMap()
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: normalizeRepoConfigMap() error = %v, want %s", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		var got, want []string
+		for key, repoConfig := range config.RepoConfigMap {
+			got = append(got, key+" = "+repoConfig.GithubEndpoint+" "+repoConfig.JiraTarget+" "+repoConfig.fullName())
+		}
+		for key, value := range test.want {
+			want = append(want, key+" = "+value)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: normalizeRepoConfigMap() =\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// backends of failed requests
+const (
+	backendGithub = "github"
+	backendJira   = "jira"
+)
+
+const (
+	// retryable requests are sent at most this many times
+	retryMaxAttempts = 4
+	retryBackoffBase = 500 * time.Millisecond
+	retryBackoffMax  = 30 * time.Second
+	// response body kept in failed requests is truncated to this size
+	failedResponseMaxBytes = 4096
+)
+
+// isRetryableStatus reports whether a response status is temporary, i.e. server
+// errors, other client errors like 400 validation and 403 permission errors fail
+// again when retried. Rate limits (429) are never retried by retryTransport, they
+// are waited out by githubRateLimitTransport and jiraBackoffTransport beneath it
+func isRetryableStatus(status int) bool {
+	return status >= 500
+}
+
+// isRetryableError reports whether a transport error is temporary, i.e. timeouts,
+// connections reset or refused and responses cut short. Other errors like TLS
+// certificate failures, malformed URLs or signing errors fail again when retried
+func isRetryableError(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	switch errorCause(err) {
+	case syscall.ECONNRESET, syscall.ECONNREFUSED, io.ErrUnexpectedEOF:
+		return true
+	}
+	return false
+}
+
+// errorCause unwraps URL, network and system call errors to the underlying error
+func errorCause(err error) error {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
+
+// retryBackoff returns the jittered delay before the retry of attempt, which
+// is at least Retry-After of the response if any
+func retryBackoff(attempt int, resp *http.Response) time.Duration {
+	d := retryBackoffBase << uint(attempt-1)
+	if d > retryBackoffMax {
+		d = retryBackoffMax
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header); ok && after > d {
+			d = after
+		}
+	}
+	return d
+}
+
+// failedRequest is a JIRA or GitHub request given up either because the error
+// is permanent or retries are exhausted
+type failedRequest struct {
+	Backend string `json:"backend"`
+	// GitHub endpoint or JIRA target name, empty for github.com and global JIRA
+	Name        string `json:"name,omitempty"`
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	RequestBody string `json:"request-body,omitempty"`
+	// status and body of the last response, or the transport error
+	Status       int       `json:"status,omitempty"`
+	ResponseBody string    `json:"response-body,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Attempts     int       `json:"attempts"`
+	Time         time.Time `json:"time"`
+}
+
+// deadLetterSink receives requests which failed permanently
+type deadLetterSink interface {
+	putFailedRequest(failed failedRequest)
+}
+
+// logDeadLetterSink only logs failed requests
+type logDeadLetterSink struct{}
+
+func (logDeadLetterSink) putFailedRequest(failed failedRequest) {
+	logrus.WithFields(logrus.Fields{
+		"backend":  failed.Backend,
+		"name":     failed.Name,
+		"method":   failed.Method,
+		"url":      failed.URL,
+		"status":   failed.Status,
+		"attempts": failed.Attempts,
+		"error":    failed.Error,
+		"response": oneLine(failed.ResponseBody),
+	}).Error("request failed permanently")
+}
+
+// retryTransport retries requests failed with temporary errors with jittered
+// exponential backoff, and passes failed requests which change something to the
+// dead-letter sink, whatever the failure is. Callers handling failures themselves
+// use a transport without sink
+type retryTransport struct {
+	backend string
+	name    string
+	sink    deadLetterSink
+	// applied checks whether a request which is not idempotent was applied
+	// although it failed, so that it is only sent again if not, nil if requests
+	// could not be checked
+	applied   func(req *http.Request) (bool, error)
+	transport http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := transportOrDefault(t.transport).RoundTrip(req)
+
+		var retryable bool
+		switch {
+		case err != nil:
+			retryable = isRetryableError(err)
+		case resp.StatusCode >= 400:
+			retryable = isRetryableStatus(resp.StatusCode)
+		default:
+			return resp, nil
+		}
+
+		if retryable && attempt < retryMaxAttempts {
+			resend, applied := t.canResend(req, err)
+			if applied {
+				logrus.WithFields(logrus.Fields{
+					"backend": t.backend,
+					"url":     req.URL.String(),
+					"attempt": attempt,
+				}).Warn("request failed but was applied, not sent again")
+				return resp, err
+			}
+			var retry *http.Request
+			if resend {
+				retry, resend = rewindRequest(req)
+			}
+			if resend {
+				delay := retryBackoff(attempt, resp)
+				l := logrus.WithFields(logrus.Fields{
+					"backend": t.backend,
+					"url":     req.URL.String(),
+					"attempt": attempt,
+				})
+				if err != nil {
+					l = l.WithError(err)
+				} else {
+					l = l.WithField("status", resp.StatusCode)
+					discardResponse(resp)
+				}
+				l.Warnf("request failed, retry after %v", delay)
+
+				select {
+				case <-time.After(delay):
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				}
+				req = retry
+				continue
+			}
+		}
+
+		// failed writes are dead letters whatever the failure is, even the ones
+		// failing again when retried, e.g. 400 of a field JIRA rejects
+		if req.Method != http.MethodGet && req.Method != http.MethodHead && t.sink != nil {
+			resp = t.putFailedRequest(req, resp, err, attempt)
+		}
+		return resp, err
+	}
+}
+
+// canResend reports whether request could be sent again, and whether it was
+// applied although it failed. Requests which are not idempotent, e.g. POST
+// creating JIRA issue or comment, may have succeeded before a timeout or a 5xx
+// response, so they are only sent again if the connection was refused, or if
+// the applied check finds nothing, otherwise they become duplicates
+func (t *retryTransport) canResend(req *http.Request, err error) (resend, applied bool) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true, false
+	}
+	if err != nil && errorCause(err) == syscall.ECONNREFUSED {
+		return true, false
+	}
+	if t.applied == nil {
+		return false, false
+	}
+	applied, checkErr := t.applied(req)
+	if checkErr != nil {
+		logrus.WithFields(logrus.Fields{
+			"backend": t.backend,
+			"url":     req.URL.String(),
+		}).WithError(checkErr).Warn("could not check whether failed request was applied, not sent again")
+		return false, false
+	}
+	return !applied, applied
+}
+
+// putFailedRequest passes failed request to the sink, and returns response with
+// the body still readable by the caller
+func (t *retryTransport) putFailedRequest(req *http.Request, resp *http.Response, err error, attempts int) *http.Response {
+	failed := failedRequest{
+		Backend:  t.backend,
+		Name:     t.name,
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, _ := ioutil.ReadAll(body)
+			body.Close()
+			failed.RequestBody = string(b)
+		}
+	}
+	if err != nil {
+		failed.Error = err.Error()
+	}
+	if resp != nil {
+		failed.Status = resp.StatusCode
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+		if len(b) > failedResponseMaxBytes {
+			b = b[:failedResponseMaxBytes]
+		}
+		failed.ResponseBody = string(b)
+	}
+	t.sink.putFailedRequest(failed)
+	return resp
+}
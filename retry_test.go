@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryableError(t *testing.T) {
+	opError := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://jira", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", err)}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", timeoutError{}, true},
+		{"wrapped timeout", &url.Error{Op: "Get", URL: "https://jira", Err: timeoutError{}}, true},
+		{"connection reset", opError(syscall.ECONNRESET), true},
+		{"connection refused", opError(syscall.ECONNREFUSED), true},
+		{"unexpected EOF", &url.Error{Op: "Get", URL: "https://jira", Err: io.ErrUnexpectedEOF}, true},
+		{"certificate", &url.Error{Op: "Get", URL: "https://jira", Err: x509.UnknownAuthorityError{}}, false},
+		{"malformed URL", &url.Error{Op: "parse", URL: ":", Err: errors.New("missing protocol scheme")}, false},
+		{"canceled", context.Canceled, false},
+		{"signing", errors.New("oauth1: signing request error"), false},
+	}
+	for _, test := range tests {
+		if got := isRetryableError(test.err); got != test.want {
+			t.Errorf("%s: isRetryableError(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+type failedRequests []failedRequest
+
+func (f *failedRequests) putFailedRequest(failed failedRequest) {
+	*f = append(*f, failed)
+}
+
+func TestRetryTransport(t *testing.T) {
+	applied := func(applied bool, err error) func(*http.Request) (bool, error) {
+		return func(*http.Request) (bool, error) { return applied, err }
+	}
+	tests := []struct {
+		name       string
+		method     string
+		statuses   []int
+		applied    func(*http.Request) (bool, error)
+		wantSent   int
+		wantStatus int
+		wantFailed int
+	}{
+		{"GET retried after 5xx", http.MethodGet, []int{500, 200}, nil, 2, 200, 0},
+		{"PUT retried after 5xx", http.MethodPut, []int{503, 204}, nil, 2, 204, 0},
+		{"POST not sent again after 5xx", http.MethodPost, []int{500, 201}, nil, 1, 500, 1},
+		{"POST sent again after 5xx if not applied", http.MethodPost, []int{500, 201}, applied(false, nil), 2, 201, 0},
+		{"POST not sent again after 5xx if applied", http.MethodPost, []int{500, 201}, applied(true, nil), 1, 500, 0},
+		{"POST not sent again if applied check fails", http.MethodPost, []int{500, 201}, applied(false, errors.New("search failed")), 1, 500, 1},
+		{"429 left to rate limit transport", http.MethodGet, []int{429, 200}, nil, 1, 429, 0},
+		{"POST 429 not retried but dead letter", http.MethodPost, []int{429, 201}, applied(false, nil), 1, 429, 1},
+		{"GET 400 left to caller", http.MethodGet, []int{400, 200}, nil, 1, 400, 0},
+		{"POST 400 not retried but dead letter", http.MethodPost, []int{400, 201}, applied(false, nil), 1, 400, 1},
+	}
+	for _, test := range tests {
+		sent := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.statuses[sent])
+			sent++
+		}))
+		var failed failedRequests
+		client := &http.Client{Transport: &retryTransport{backend: backendJira, sink: &failed, applied: test.applied}}
+		req, _ := http.NewRequest(test.method, server.URL, strings.NewReader("{}"))
+		resp, err := client.Do(req)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if sent != test.wantSent || resp.StatusCode != test.wantStatus || len(failed) != test.wantFailed {
+			t.Errorf("%s: sent %d times with status %d and %d dead letters, want %d times with status %d and %d dead letters",
+				test.name, sent, resp.StatusCode, len(failed), test.wantSent, test.wantStatus, test.wantFailed)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// schedule decides when periodic synchronization runs
+type schedule interface {
+	// next returns the first run time after t, zero if never
+	next(t time.Time) time.Time
+}
+
+// parseSchedule parses a Go duration, e.g. "30m", or a cron expression of five
+// fields "minute hour day-of-month month day-of-week", e.g. "0 */6 * * *"
+func parseSchedule(spec string) (schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval '%s' should be positive", spec)
+		}
+		return intervalSchedule(d), nil
+	}
+	return parseCron(spec)
+}
+
+// intervalSchedule runs at fixed interval after the previous run finishes
+type intervalSchedule time.Duration
+
+func (interval intervalSchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(interval))
+}
+
+// cronSchedule runs at times matching all fields in server local time, except
+// that day-of-month and day-of-week match either one when both are restricted
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("'%s' is neither duration nor cron expression of 5 fields", spec)
+	}
+	var cron cronSchedule
+	var err error
+	bounds := []struct {
+		field    *map[int]bool
+		min, max int
+	}{
+		{&cron.minute, 0, 59},
+		{&cron.hour, 0, 23},
+		{&cron.dom, 1, 31},
+		{&cron.month, 1, 12},
+		{&cron.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		*b.field, err = parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression '%s': %v", spec, err)
+		}
+	}
+	// both 0 and 7 are Sunday
+	if cron.dow[7] {
+		cron.dow[0] = true
+	}
+	cron.domAny = strings.HasPrefix(fields[2], "*")
+	cron.dowAny = strings.HasPrefix(fields[4], "*")
+	return &cron, nil
+}
+
+// parseCronField parses comma separated "*", "N", "N-M" with optional "/STEP"
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			stepped = true
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step '%s'", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			hi = lo
+			if stepped {
+				// "N/STEP" is from N to the maximum
+				hi = max
+			}
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", part)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return nil, fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (cron *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// no match in 5 years means never, e.g. February 30
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		y, m, d := t.Date()
+		switch {
+		case !cron.month[int(m)]:
+			t = startOfDay(t, time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location()))
+		case !cron.dayMatches(t):
+			t = startOfDay(t, time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()))
+		case !cron.hour[t.Hour()]:
+			// Truncate works on absolute time, which is not the start of the
+			// local hour in zones of half-hour offsets, and time.Date of the hour
+			// skipped by daylight saving time goes back an hour
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !cron.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// startOfDay returns midnight of a later day, or the first hour after t if
+// midnight is skipped by daylight saving time, e.g. in America/Santiago
+func startOfDay(t, midnight time.Time) time.Time {
+	if midnight.After(t) {
+		return midnight
+	}
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+func (cron *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := cron.dom[t.Day()], cron.dow[int(t.Weekday())]
+	switch {
+	case cron.domAny:
+		return dow
+	case cron.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// runScheduledSyncs synchronizes repos incrementally on schedule until stop is
+// closed. Each repo runs on its own sync-schedule, or the global one, and repos
+// due at the same time are synchronized by one presync. Runs never overlap as
+// the next runs are scheduled after one finishes
+func (s *Server) runScheduledSyncs(global schedule, stop <-chan struct{}) {
+	l := logrus.WithField("event-type", "scheduledSync")
+	start := time.Now()
+	lastRuns := map[string]time.Time{}
+	for {
+		// repos of routing rules are listed again, as they come and go
+		repoConfigs, err := s.syncRepoConfigs(l, nil)
+		if err != nil {
+			l.WithError(err).Warn("list repos error, repos not listed are scheduled later")
+		}
+		nextRuns := nextRepoRuns(repoConfigs, global, lastRuns, start)
+		var next time.Time
+		for _, t := range nextRuns {
+			if next.IsZero() || t.Before(next) {
+				next = t
+			}
+		}
+		if next.IsZero() {
+			l.Warn("scheduled synchronization never runs again")
+			return
+		}
+		l.Infof("next scheduled synchronization at %v", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		var repos []string
+		for key, t := range nextRuns {
+			if !t.After(next) {
+				repos = append(repos, key)
+			}
+		}
+		sort.Strings(repos)
+		l.Infof("scheduled synchronization of %s", strings.Join(repos, ", "))
+		if _, err := s.presync(repos); err != nil && err != errShuttingDown {
+			l.WithError(err).Error("scheduled synchronization failed")
+		}
+		now := time.Now()
+		for _, key := range repos {
+			lastRuns[key] = now
+		}
+	}
+}
+
+// nextRepoRuns returns the next run time of each repo by key, after the last run
+// of repo, or after start if repo never ran. Repos without schedule never run
+func nextRepoRuns(repoConfigs []RepoConfig, global schedule, lastRuns map[string]time.Time, start time.Time) map[string]time.Time {
+	nextRuns := map[string]time.Time{}
+	for _, repoConfig := range repoConfigs {
+		sched := repoConfig.schedule
+		if sched == nil {
+			sched = global
+		}
+		if sched == nil {
+			continue
+		}
+		key := repoCursorKey(repoConfig)
+		last, ok := lastRuns[key]
+		if !ok {
+			last = start
+		}
+		if next := sched.next(last); !next.IsZero() {
+			nextRuns[key] = next
+		}
+	}
+	return nextRuns
+}
+
+// issueLocks serializes synchronization of the same GitHub issue by webhooks,
+// presync and retries, so that they never update the same JIRA issue at the
+// same time
+type issueLocks struct {
+	mu    sync.Mutex
+	locks map[string]*issueLock
+}
+
+type issueLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks GitHub issue of repo, and returns the unlock function
+func (locks *issueLocks) lock(repoConfig RepoConfig, githubIssueID int64) func() {
+	key := fmt.Sprintf("%s:%d", repoConfig.GithubEndpoint, githubIssueID)
+
+	locks.mu.Lock()
+	if locks.locks == nil {
+		locks.locks = map[string]*issueLock{}
+	}
+	l, ok := locks.locks[key]
+	if !ok {
+		l = &issueLock{}
+		locks.locks[key] = l
+	}
+	l.refs++
+	locks.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		locks.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(locks.locks, key)
+		}
+		locks.mu.Unlock()
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{"30m", "1h30m", "0 */6 * * *", "*/15 9-17 * * 1-5", "0 0 1,15 * *", "0 12 * * 7"} {
+		if _, err := parseSchedule(spec); err != nil {
+			t.Errorf("parseSchedule(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "0s", "-1m", "0 24 * * *", "60 * * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "* * * *"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+30*60)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// half-hour offset zone
+		{"0 9 * * *", time.Date(2026, 10, 18, 8, 10, 0, 0, kolkata), time.Date(2026, 10, 18, 9, 0, 0, 0, kolkata)},
+		{"30 10 * * *", time.Date(2026, 10, 18, 8, 10, 0, 0, kolkata), time.Date(2026, 10, 18, 10, 30, 0, 0, kolkata)},
+		{"0 9 * * *", time.Date(2026, 10, 18, 9, 0, 0, 0, kolkata), time.Date(2026, 10, 19, 9, 0, 0, 0, kolkata)},
+		// daylight saving time starts at 2:00 on 2026-03-08 and ends at 2:00 on 2026-11-01
+		{"0 3 * * *", time.Date(2026, 3, 8, 0, 30, 0, 0, newYork), time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		{"0 12 * * *", time.Date(2026, 3, 8, 0, 30, 0, 0, newYork), time.Date(2026, 3, 8, 12, 0, 0, 0, newYork)},
+		{"0 3 * * *", time.Date(2026, 11, 1, 0, 30, 0, 0, newYork), time.Date(2026, 11, 1, 3, 0, 0, 0, newYork)},
+		// midnight is skipped when daylight saving time starts on 2026-09-06
+		{"0 12 * * *", time.Date(2026, 9, 5, 13, 0, 0, 0, santiago), time.Date(2026, 9, 6, 12, 0, 0, 0, santiago)},
+		{"0 12 * 10 *", time.Date(2026, 9, 5, 13, 0, 0, 0, santiago), time.Date(2026, 10, 1, 12, 0, 0, 0, santiago)},
+		// steps
+		{"*/15 * * * *", time.Date(2026, 10, 18, 8, 10, 0, 0, time.UTC), time.Date(2026, 10, 18, 8, 15, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2026, 10, 18, 8, 10, 0, 0, time.UTC), time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 10, 18, 13, 10, 0, 0, time.UTC), time.Date(2026, 10, 18, 17, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 10, 18, 17, 10, 0, 0, time.UTC), time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 10/6 * * *", time.Date(2026, 10, 18, 17, 10, 0, 0, time.UTC), time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC)},
+		// day of month or day of week when both are restricted, 2026-10-18 is Sunday
+		{"0 0 20 * 1", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * 3", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)},
+		// February 30 never comes
+		{"0 0 30 2 *", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 0 29 2 *", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		sched, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", test.spec, err)
+			continue
+		}
+		if got := sched.next(test.from); !got.Equal(test.want) {
+			t.Errorf("%q next after %v = %v, want %v", test.spec, test.from, got, test.want)
+		}
+	}
+}
+
+func TestIntervalScheduleNext(t *testing.T) {
+	sched, _ := parseSchedule("30m")
+	from := time.Date(2026, 10, 18, 8, 10, 0, 0, time.UTC)
+	if got, want := sched.next(from), from.Add(30*time.Minute); !got.Equal(want) {
+		t.Errorf("next after %v = %v, want %v", from, got, want)
+	}
+}
+
+func TestNextRepoRuns(t *testing.T) {
+	start := time.Date(2026, 10, 18, 8, 10, 0, 0, time.UTC)
+	global, _ := parseSchedule("1h")
+	repo := func(owner, name, spec string) RepoConfig {
+		repoConfig := RepoConfig{GithubOwner: owner, name: name, SyncSchedule: spec}
+		if spec != "" {
+			repoConfig.schedule, _ = parseSchedule(spec)
+		}
+		return repoConfig
+	}
+	repoConfigs := []RepoConfig{
+		repo("pingcap", "tidb", ""),
+		repo("pingcap", "tikv", "30m"),
+		repo("pingcap", "pd", "0 12 * * *"),
+	}
+
+	tests := []struct {
+		name     string
+		global   schedule
+		lastRuns map[string]time.Time
+		want     map[string]time.Time
+	}{
+		{
+			name:   "never ran",
+			global: global,
+			want: map[string]time.Time{
+				"pingcap/tidb": start.Add(time.Hour),
+				"pingcap/tikv": start.Add(30 * time.Minute),
+				"pingcap/pd":   time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "after last runs",
+			global:   global,
+			lastRuns: map[string]time.Time{"pingcap/tikv": start.Add(40 * time.Minute), "pingcap/pd": time.Date(2026, 10, 18, 12, 5, 0, 0, time.UTC)},
+			want: map[string]time.Time{
+				"pingcap/tidb": start.Add(time.Hour),
+				"pingcap/tikv": start.Add(70 * time.Minute),
+				"pingcap/pd":   time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "no global schedule",
+			want: map[string]time.Time{
+				"pingcap/tikv": start.Add(30 * time.Minute),
+				"pingcap/pd":   time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, test := range tests {
+		got := nextRepoRuns(repoConfigs, test.global, test.lastRuns, start)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: nextRepoRuns() = %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for key, want := range test.want {
+			if !got[key].Equal(want) {
+				t.Errorf("%s: next run of %s = %v, want %v", test.name, key, got[key], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secret reference prefixes of credential config values
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+const redactedSecret = "******"
+
+// secret is a credential config value, which is either the plain value, or a
+// reference "env:VAR" to environment variable, or "file:/path" to file content.
+// File is re-read whenever it changes on disk, so that secrets could be rotated
+// without restarting the server
+type secret struct {
+	ref string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+func newSecret(ref string) *secret {
+	return &secret{ref: ref}
+}
+
+// Value resolves the secret
+func (s *secret) Value() (string, error) {
+	switch {
+	case strings.HasPrefix(s.ref, secretEnvPrefix):
+		name := strings.TrimPrefix(s.ref, secretEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not exists", name)
+		}
+		return value, nil
+	case strings.HasPrefix(s.ref, secretFilePrefix):
+		return s.fileValue(strings.TrimPrefix(s.ref, secretFilePrefix))
+	default:
+		return s.ref, nil
+	}
+}
+
+func (s *secret) fileValue(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !info.ModTime().Equal(s.modTime) || info.Size() != s.size {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		// trailing newline is almost always added by editors and secret mounts
+		s.value = strings.TrimRight(string(b), "\r\n")
+		s.modTime = info.ModTime()
+		s.size = info.Size()
+	}
+
+	return s.value, nil
+}
+
+// String never prints the secret value itself
+func (s *secret) String() string {
+	return redactSecretRef(s.ref)
+}
+
+// redactSecretRef keeps env and file references, which are not sensitive
+func redactSecretRef(ref string) string {
+	if ref == "" || strings.HasPrefix(ref, secretEnvPrefix) || strings.HasPrefix(ref, secretFilePrefix) {
+		return ref
+	}
+	return redactedSecret
+}
+
+// keyFileSecret returns the secret of a private key config value, plain value
+// is a file path for compatibility
+func keyFileSecret(ref string) *secret {
+	if strings.HasPrefix(ref, secretEnvPrefix) || strings.HasPrefix(ref, secretFilePrefix) {
+		return newSecret(ref)
+	}
+	return newSecret(secretFilePrefix + ref)
+}
+
+// rsaKeySecret is a PEM encoded RSA private key secret, parsed again when rotated
+type rsaKeySecret struct {
+	*secret
+
+	mu  sync.Mutex
+	pem string
+	key *rsa.PrivateKey
+}
+
+func newRSAKeySecret(ref string) (*rsaKeySecret, error) {
+	s := &rsaKeySecret{secret: keyFileSecret(ref)}
+	// parse once to find bad key early
+	if _, err := s.Key(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Key returns the parsed private key
+func (s *rsaKeySecret) Key() (*rsa.PrivateKey, error) {
+	value, err := s.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil || value != s.pem {
+		key, err := parseRSAPrivateKey([]byte(value))
+		if err != nil {
+			return nil, err
+		}
+		s.pem, s.key = value, key
+	}
+	return s.key, nil
+}
+
+// basicAuthTransport authenticates requests with HTTP basic authentication,
+// the password is resolved per request to pick up rotation
+type basicAuthTransport struct {
+	username  string
+	password  *secret
+	transport http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	password, err := t.password.Value()
+	if err != nil {
+		return nil, err
+	}
+	req2 := cloneRequest(req)
+	req2.SetBasicAuth(t.username, password)
+	return transportOrDefault(t.transport).RoundTrip(req2)
+}
+
+// checkSecrets resolves all credential fields tagged by `secret:"true"`, to
+// find missing environment variables and unreadable files early
+func checkSecrets(v interface{}) error {
+	var err error
+	walkSecrets(reflect.ValueOf(v), func(name, ref string) {
+		if err != nil || ref == "" {
+			return
+		}
+		if _, e := newSecret(ref).Value(); e != nil {
+			err = fmt.Errorf("%s: %v", name, e)
+		}
+	})
+	return err
+}
+
+func walkSecrets(v reflect.Value, f func(name, ref string)) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkSecrets(v.Elem(), f)
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			walkSecrets(v.MapIndex(k), f)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" || (field.Anonymous && field.Type.Kind() != reflect.Struct) {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				f(field.Name, v.Field(i).String())
+				continue
+			}
+			walkSecrets(v.Field(i), f)
+		}
+	}
+}
+
+// redactSecrets returns a printable copy of v, where all fields tagged by
+// `secret:"true"` are redacted, used for logging config
+func redactSecrets(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil
+	}
+	if v.CanInterface() {
+		if stringer, ok := v.Interface().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return redactSecrets(v.Elem())
+	case reflect.Map:
+		m := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			m[fmt.Sprint(k)] = redactSecrets(v.MapIndex(k))
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		var s []interface{}
+		for i := 0; i < v.Len(); i++ {
+			s = append(s, redactSecrets(v.Index(i)))
+		}
+		return s
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				// fields of embedded config are promoted
+				if promoted, ok := redactSecrets(v.Field(i)).(map[string]interface{}); ok {
+					for k, v := range promoted {
+						m[k] = v
+					}
+					continue
+				}
+			}
+			if field.PkgPath != "" || field.Anonymous {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				m[field.Name] = redactSecretRef(v.Field(i).String())
+				continue
+			}
+			m[field.Name] = redactSecrets(v.Field(i))
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
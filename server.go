@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
-	jira "github.com/Tom-Xie/go-jira"
 	githubGoogle "github.com/google/go-github/github"
 
 	logrus "github.com/sirupsen/logrus"
@@ -16,66 +17,112 @@ import (
 // Server implements http.Handler. It validates incoming GitHub webhooks and
 // then dispatches them to the appropriate handles.
 type Server struct {
-	githubClient *githubGoogle.Client
-	jiraClient   *jira.Client
+	// GitHub API clients by endpoint name, github.com is the empty name
+	githubClients map[string]*githubGoogle.Client
+	// JIRA targets by name, the global JIRA is the empty name
+	jiraTargets map[string]*jiraTarget
+	// planned JIRA actions in dry-run mode, nil otherwise
+	dryRunPlan *jiraDryRunPlan
+	// serializes merges of duplicate JIRA issues
+	mergeMu sync.Mutex
+	// hashes of GitHub issues last synchronized, to skip unchanged issues
+	issueHashes *issueHashStore
+	// keeps failed events, issues and requests for retry
+	deadLetters *deadLetterStore
+	// serializes synchronization of the same GitHub issue
+	issueLocks issueLocks
+	// 1 while presync is running, presync never overlaps with itself
+	presyncRunning int32
+	// per repo latest updated time of GitHub issues synchronized
+	cursors *syncCursorStore
 
 	// how to save Config, global conf with local client conf?
 	Config *Config
 
-	// Tracks running handlers for graceful shutdown
-	// wg sync.WaitGroup
+	// Tracks running handlers and scheduled synchronization for graceful shutdown
+	wg sync.WaitGroup
+	// closed on shutdown, guarded by stopMu together with adding to wg
+	stop   chan struct{}
+	stopMu sync.Mutex
 }
 
 func newServer(Config *Config) (*Server, error) {
+	// nothing is applied to JIRA in dry-run, failures are only logged
+	deadLetters := newDeadLetterStore(Config.DeadLetterDir)
+	deadLetters.readOnly = Config.DryRun
 
-	githubTransport := &githubGoogle.BasicAuthTransport{
-		Username: Config.GithubUsername,
-		Password: Config.GithubPassword,
-	}
-	githubClient := githubGoogle.NewClient(githubTransport.Client())
-
-	jiraTransport := jira.BasicAuthTransport{
-		Username: Config.JiraUsername,
-		Password: Config.JiraPassword,
-	}
-	// err only happens when url is wrong, we gaurantee the url in the Configuration
-	// reading phase, which frees us from err checking
-	jiraClient, err := jira.NewClient(jiraTransport.Client(), Config.JiraBaseURL)
+	githubClients, err := newGithubClients(Config, deadLetters)
 	if err != nil {
 		return nil, err
 	}
 
-	logrus.Debug("start get JIRA custom fields")
-
-	// get custom JIRA custom field ID and save it in Config
-	Config.FieldIDs, err = getJiraFiledIDs(jiraClient)
+	jiraTargets, err := newJiraTargets(Config, deadLetters)
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range Config.FieldIDs {
-		logrus.Debugf("%v: %v", k, v)
+
+	issueHashes, err := loadIssueHashStore(issueHashFileName)
+	if err != nil {
+		logrus.WithError(err).Warn("read issue hashes error, all issues will be compared")
+		issueHashes = &issueHashStore{path: issueHashFileName, hashes: map[string]string{}}
 	}
 
-	logrus.Debug("finish get JIRA custom fields")
+	if Config.UseSyncCursors {
+		Config.readLegacyLastSyncTime()
+	}
+	cursors, err := loadSyncCursorStore(syncCursorFileName)
+	if err != nil {
+		logrus.WithError(err).Warn("read sync cursors error, all repos will be synchronized since github-sincetime")
+		cursors = newSyncCursorStore(syncCursorFileName)
+	}
 
 	s := &Server{
-		githubClient: githubClient,
-		jiraClient:   jiraClient,
-		Config:       Config,
+		githubClients: githubClients,
+		jiraTargets:   jiraTargets,
+		Config:        Config,
+		issueHashes:   issueHashes,
+		deadLetters:   deadLetters,
+		cursors:       cursors,
+		stop:          make(chan struct{}),
+	}
+
+	if Config.DryRun {
+		s.dryRunPlan = &jiraDryRunPlan{}
+		for name, target := range jiraTargets {
+			target.issueService = newJiraDryRunIssueService(name, target.issueService, s.dryRunPlan)
+		}
 	}
-	return s, err
+	return s, nil
 }
 
 // ServeHTTP validates an incoming webhook and puts it into the event channel.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.track() {
+		// GitHub shows the failed delivery, which could be redelivered after restart
+		http.Error(w, "503 Service Unavailable: shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.wg.Done()
+
 	eventType, eventGUID, payload, ok := validateWebhook(w, r)
 	if !ok {
 		return
 	}
 	fmt.Fprint(w, "Event received. Have a nice day.")
 
+	delivery := newWebhookDelivery(eventType, eventGUID, payload, r.Header)
+	if s.Config.PayloadDir != "" {
+		if err := storeWebhookDelivery(s.Config.PayloadDir, delivery); err != nil {
+			logrus.WithError(err).Warn("Error storing event.")
+		}
+	}
+
+	// errors are logged with the event context by demuxEvent
 	if err := s.demuxEvent(eventType, eventGUID, payload, r.Header); err != nil {
-		logrus.WithError(err).Error("Error parsing event.")
+		logrus.WithError(err).Debug("Error handling event.")
+		if err != errUnsupportedEvent {
+			s.deadLetters.putFailedEvent(delivery, err)
+		}
 	}
 }
 
@@ -140,6 +187,9 @@ func validateWebhook(w http.ResponseWriter, r *http.Request) (string, string, []
 	return eventType, eventGUID, payload, true
 }
 
+// errUnsupportedEvent is returned for events not synchronized, which are not failures
+var errUnsupportedEvent = errors.New("Unsupported type")
+
 func (s *Server) demuxEvent(eventType, eventGUID string, payload []byte, h http.Header) error {
 	l := logrus.WithFields(logrus.Fields{
 		"event-type": eventType,
@@ -151,27 +201,29 @@ func (s *Server) demuxEvent(eventType, eventGUID string, payload []byte, h http.
 	case "issues":
 		var i githubGoogle.IssuesEvent
 		if err := json.Unmarshal(payload, &i); err != nil {
+			l.WithError(err).Error("Error parsing event.")
 			return err
 		}
-		s.handleIssueEvent(l, i)
+		return s.handleIssueEvent(l, i, githubWebhookHost(h, i.GetRepo()), githubIssueAuthorAssociation(payload))
 	case "issue_comment":
 		var ic githubGoogle.IssueCommentEvent
 		if err := json.Unmarshal(payload, &ic); err != nil {
+			l.WithError(err).Error("Error parsing event.")
 			return err
 		}
-		s.handleIssueCommentEvent(l, ic)
+		return s.handleIssueCommentEvent(l, ic, githubWebhookHost(h, ic.GetRepo()))
 	default:
 		l.WithFields(logrus.Fields{
 			"event-type": eventType,
 		}).Warn("Unsupported type")
-		return errors.New("Unsupported type")
+		return errUnsupportedEvent
 	}
-
-	return nil
 }
 
-func (s *Server) handleIssueEvent(l *logrus.Entry, i githubGoogle.IssuesEvent) {
+// handleIssueEvent handles issue event, events rejected are not errors
+func (s *Server) handleIssueEvent(l *logrus.Entry, i githubGoogle.IssuesEvent, host, authorAssociation string) error {
 	l = l.WithFields(logrus.Fields{
+		"host":         host,
 		"org":          i.GetRepo().GetOwner().GetLogin(),
 		"repo":         i.GetRepo().GetName(),
 		"pr":           i.GetIssue().GetNumber(),
@@ -183,17 +235,48 @@ func (s *Server) handleIssueEvent(l *logrus.Entry, i githubGoogle.IssuesEvent) {
 
 	if i.GetIssue().IsPullRequest() {
 		l.Infof("not handle pull request issue")
-		return
+		return nil
 	}
 
-	if err := s.demuxIssueEvent(l, i); err != nil {
+	repoConfig, err := s.eventRepoConfig(host, i.GetRepo())
+	if err != nil {
+		l.WithError(err).Warn("reject IssueEvent.")
+		return nil
+	}
+
+	// in case label event with creating new issue, wait before locking the
+	// issue so that the open event is not blocked
+	switch i.GetAction() {
+	case "assigned", "labeled":
+		s.waitIssueCreated()
+	}
+
+	unlock := s.issueLocks.lock(repoConfig, i.GetIssue().GetID())
+	defer unlock()
+	if err := s.demuxIssueEvent(l, i, repoConfig, authorAssociation); err != nil {
 		l.WithError(err).Error("Error handling IssueEvent.")
+		return err
 	}
 
+	return nil
+}
+
+// issueCreateWait is how long assign and label events wait for the open event
+// of the same new GitHub issue to create the JIRA issue
+const issueCreateWait = 10 * time.Second
+
+// waitIssueCreated waits for issueCreateWait, or until shutdown
+func (s *Server) waitIssueCreated() {
+	select {
+	case <-time.After(issueCreateWait):
+	case <-s.stop:
+	}
 }
 
-func (s *Server) handleIssueCommentEvent(l *logrus.Entry, ic githubGoogle.IssueCommentEvent) {
+// handleIssueCommentEvent handles issue comment event, events rejected are not errors
+func (s *Server) handleIssueCommentEvent(l *logrus.Entry, ic githubGoogle.IssueCommentEvent, host string) error {
 	l = l.WithFields(logrus.Fields{
+		"host":         host,
 		"org":          ic.GetRepo().GetOwner().GetLogin(),
 		"repo":         ic.GetRepo().GetName(),
 		"pr":           ic.GetIssue().GetNumber(),
@@ -205,50 +288,76 @@ func (s *Server) handleIssueCommentEvent(l *logrus.Entry, ic githubGoogle.IssueC
 
 	if ic.GetIssue().IsPullRequest() {
 		l.Infof("not handle pull request issue")
-		return
+		return nil
+	}
+
+	repoConfig, err := s.eventRepoConfig(host, ic.GetRepo())
+	if err != nil {
+		l.WithError(err).Warn("reject IssueCommentEvent.")
+		return nil
 	}
 
-	if err := s.demuxIssueCommentEvent(l, ic); err != nil {
+	unlock := s.issueLocks.lock(repoConfig, ic.GetIssue().GetID())
+	defer unlock()
+	if err := s.demuxIssueCommentEvent(l, ic, repoConfig); err != nil {
 		l.WithError(err).Error("Error handling IssueCommentEvent.")
+		return err
 	}
 
+	return nil
+}
+
+// eventRepoConfig finds the config of repo where the webhook event comes from,
+// events of repos not configured, including same name repos of other owners or
+// other GitHub endpoints, are rejected
+func (s *Server) eventRepoConfig(host string, repo *githubGoogle.Repository) (RepoConfig, error) {
+	endpoint, ok := s.Config.githubEndpointByHost(host)
+	if !ok {
+		return RepoConfig{}, fmt.Errorf("GitHub host %s is not configured", host)
+	}
+	repoConfig, ok := s.Config.getRepoConfig(endpoint, repo.GetOwner().GetLogin(), repo.GetName())
+	if !ok {
+		return RepoConfig{}, fmt.Errorf("repo %s is not configured", repo.GetFullName())
+	}
+	return repoConfig, nil
 }
 
-// demuxIssueEvent dispatches different github issue events to different handle function
-func (s *Server) demuxIssueEvent(l *logrus.Entry, i githubGoogle.IssuesEvent) error {
+// demuxIssueEvent dispatches different github issue events to different handle function,
+// author association is used by issue filter, as go-github Issue lacks it
+func (s *Server) demuxIssueEvent(l *logrus.Entry, i githubGoogle.IssuesEvent, repoConfig RepoConfig, authorAssociation string) error {
 	var err error
 	switch i.GetAction() {
 	case "opened":
-		err = s.handleIssueEventOpen(l, i)
+		err = s.handleIssueEventOpen(l, i, repoConfig, authorAssociation)
 	case "closed":
-		err = s.handleIssueEventClosed(l, i)
+		err = s.handleIssueEventClosed(l, i, repoConfig)
 	case "reopened":
-		err = s.handleIssueEventReopen(l, i)
+		err = s.handleIssueEventReopen(l, i, repoConfig)
 	case "edited":
-		_, err = s.handleIssueEventEdit(l, i)
+		_, err = s.handleIssueEventEdit(l, i, repoConfig)
 	case "assigned":
-		err = s.handleIssueEventAssign(l, i)
+		err = s.handleIssueEventAssign(l, i, repoConfig)
 	case "unassigned":
-		err = s.handleIssueEventUnassign(l, i)
+		err = s.handleIssueEventUnassign(l, i, repoConfig)
 	case "labeled":
-		err = s.handleIssueEventLabel(l, i)
+		err = s.handleIssueEventLabel(l, i, repoConfig, authorAssociation)
 	case "unlabeled":
-		err = s.handleIssueEventUnlabel(l, i)
+		err = s.handleIssueEventUnlabel(l, i, repoConfig)
 	default:
 	}
 	return err
 }
 
 // demuxIssueCommentEvent dispatches different github issue comments events to different handle function
-func (s *Server) demuxIssueCommentEvent(l *logrus.Entry, ic githubGoogle.IssueCommentEvent) error {
+func (s *Server) demuxIssueCommentEvent(l *logrus.Entry, ic githubGoogle.IssueCommentEvent, repoConfig RepoConfig) error {
 	var err error
 	switch ic.GetAction() {
 	case "created":
-		err = s.handleIssueCommentCreate(l, ic)
+		err = s.handleIssueCommentCreate(l, ic, repoConfig)
 	case "edited":
-		err = s.handleIssueCommentEdit(l, ic)
+		err = s.handleIssueCommentEdit(l, ic, repoConfig)
 	case "deleted":
-		err = s.handleIssueCommentDelete(l, ic)
+		err = s.handleIssueCommentDelete(l, ic, repoConfig)
 	default:
 	}
 	return err
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// errShuttingDown is returned by presync interrupted by shutdown
+var errShuttingDown = errors.New("server is shutting down")
+
+// track counts a webhook handler or background work in wg, unless server is
+// shutting down. wg.Done must be called if it returns true
+func (s *Server) track() bool {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	if s.stopping() {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// stopping reports whether server is shutting down, presync stops starting
+// new repos and issues then
+func (s *Server) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopServing starts shutdown, it is safe to be called more than once
+func (s *Server) stopServing() {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	if !s.stopping() {
+		close(s.stop)
+	}
+}
+
+// watchSignals starts shutdown on the first SIGINT or SIGTERM, the second one
+// kills the process immediately. One-shot commands finish issues and events in
+// progress and leave the rest
+func (s *Server) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		logrus.Infof("received %v, finishing in-flight work, send it again to exit immediately", sig)
+		s.stopServing()
+	}()
+}
+
+// shutdown stops accepting webhook deliveries, waits for in-flight handlers and
+// scheduled synchronization up to shutdown-timeout, and saves sync cursors and
+// issue hashes. Failed events are already kept as dead letters, nothing else is
+// queued in memory
+func (s *Server) shutdown(httpServer *http.Server) error {
+	s.stopServing()
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.shutdownTimeout)
+	defer cancel()
+
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("Error shutting down HTTP server")
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logrus.Info("all in-flight work is drained")
+	case <-ctx.Done():
+		err = ctx.Err()
+		logrus.WithError(err).Warn("shutdown timed out, in-flight work is abandoned")
+	}
+
+	s.saveState()
+	return err
+}
+
+// saveState saves sync cursors and issue hashes, which presync saves as it goes
+// as well, nothing is written in dry-run
+func (s *Server) saveState() {
+	if s.Config.DryRun {
+		return
+	}
+	if err := s.cursors.save(); err != nil {
+		logrus.WithError(err).Warnf("write \"%s\" error", syncCursorFileName)
+	}
+	if err := s.issueHashes.save(); err != nil {
+		logrus.WithError(err).Warnf("write \"%s\" error", issueHashFileName)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// default templates reproduce the layouts of JIRA issue and comment footers,
+// any field of jiraIssueTemplateData/jiraCommentTemplateData could be used
+const (
+	defaultSummaryTemplate     = `{{.Title}}`
+	defaultDescriptionTemplate = `{{.Body}}
+
+----
+
+Create issue [(#{{.Number}})|{{.Link}}] from GitHub user [{{.UserLogin}}|{{.UserLink}}]{{if .UserName}} ({{.UserName}}){{end}} at {{.Time}}`
+	defaultCommentTemplate = `Comment [(ID {{.ID}})|{{.Link}}] from GitHub user [{{.UserLogin}}|{{.UserLink}}]{{if .UserName}} ({{.UserName}}){{end}} at {{.Time}}
+
+----
+
+{{.Body}}
+`
+)
+
+// jCommentIDRegex matches the footer of default comment template, and
+// jCommentAnchorRegex matches the anchor appended to comments of other templates,
+// the anchor is invisible in JIRA wiki, ADF comments read by API v2 may escape it
+var jCommentIDRegex = regexp.MustCompile("^Comment \\[\\(ID (\\d+)\\)\\|")
+var jCommentAnchorRegex = regexp.MustCompile(`\\?\{anchor:github-comment-(\d+)\\?\}`)
+
+// jiraIssueTemplateData is the data of summary and description templates
+type jiraIssueTemplateData struct {
+	Owner string
+	Repo  string
+
+	Title         string
+	Body          string // Body is transformed to JIRA wiki
+	RawBody       string // RawBody is the original GitHub Markdown
+	Number        string
+	Link          string
+	UserLogin     string
+	UserLink      string
+	UserName      string
+	Time          string
+	AssigneeLogin string
+	Labels        []string
+	LabelTag      string
+	Milestone     string
+}
+
+// jiraCommentTemplateData is the data of comment templates
+type jiraCommentTemplateData struct {
+	Owner string
+	Repo  string
+
+	ID        string
+	Body      string // Body is transformed to JIRA wiki
+	RawBody   string // RawBody is the original GitHub Markdown
+	Link      string
+	UserLogin string
+	UserLink  string
+	UserName  string
+	Time      string
+}
+
+// JIRA rejects summary longer than 255 characters
+const jiraSummaryMaxLength = 255
+
+// jiraTemplates are the templates and summary rules of a repo
+type jiraTemplates struct {
+	summary     *template.Template
+	description *template.Template
+	comment     *template.Template
+
+	summaryStrip     []*regexp.Regexp
+	summaryLabelTags map[string]string
+}
+
+func parseJiraTemplates(repoConfig RepoConfig) (*jiraTemplates, error) {
+	orDefault := func(text, defaultText string) string {
+		if text == "" {
+			return defaultText
+		}
+		return text
+	}
+
+	var err error
+	t := &jiraTemplates{}
+	t.summary, err = template.New("summary").Parse(orDefault(repoConfig.SummaryTemplate, defaultSummaryTemplate))
+	if err != nil {
+		return nil, err
+	}
+	t.description, err = template.New("description").Parse(orDefault(repoConfig.DescriptionTemplate, defaultDescriptionTemplate))
+	if err != nil {
+		return nil, err
+	}
+	t.comment, err = template.New("comment").Parse(orDefault(repoConfig.CommentTemplate, defaultCommentTemplate))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range repoConfig.SummaryStripPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		t.summaryStrip = append(t.summaryStrip, re)
+	}
+	t.summaryLabelTags = repoConfig.SummaryLabelTags
+
+	// execute with empty data to find unknown fields early
+	for _, tmpl := range []*template.Template{t.summary, t.description} {
+		if _, err := executeTemplate(tmpl, jiraIssueTemplateData{}); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := executeTemplate(t.comment, jiraCommentTemplateData{}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// stripTitle applies the summary strip patterns to GitHub issue title
+func (t *jiraTemplates) stripTitle(title string) string {
+	for _, re := range t.summaryStrip {
+		title = re.ReplaceAllString(title, "")
+	}
+	return strings.TrimSpace(title)
+}
+
+// labelTag returns the summary tag of the first label found in summary label tags
+func (t *jiraTemplates) labelTag(labels []string) string {
+	for _, label := range labels {
+		if tag, ok := t.summaryLabelTags[label]; ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+// truncateSummary makes summary a valid JIRA summary, which is a single line
+// of at most 255 characters
+func truncateSummary(summary string) string {
+	summary = strings.Join(strings.Fields(summary), " ")
+	if utf8.RuneCountInString(summary) <= jiraSummaryMaxLength {
+		return summary
+	}
+	runes := []rune(summary)
+	return strings.TrimRight(string(runes[:jiraSummaryMaxLength-1]), " ") + "…"
+}
+
+var defaultJiraTemplates, _ = parseJiraTemplates(RepoConfig{})
+
+// githubCommentID finds the GitHub comment ID of a JIRA comment created by syncer
+func githubCommentID(jiraCommentBody string) (int64, bool) {
+	matches := jCommentIDRegex.FindStringSubmatch(jiraCommentBody)
+	if matches == nil {
+		matches = jCommentAnchorRegex.FindStringSubmatch(jiraCommentBody)
+	}
+	if matches == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// withCommentAnchor makes sure the comment could be correlated with the GitHub
+// comment, no matter which template renders it
+func withCommentAnchor(jiraCommentBody, commentID string) string {
+	if id, ok := githubCommentID(jiraCommentBody); ok && strconv.FormatInt(id, 10) == commentID {
+		return jiraCommentBody
+	}
+	return fmt.Sprintf("%s\n{anchor:github-comment-%s}", jiraCommentBody, commentID)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary string
+		want    string
+	}{
+		{"short", "panic in planner", "panic in planner"},
+		{"whitespace collapsed", "  panic\tin\n\nplanner \r\n", "panic in planner"},
+		{"max length kept", strings.Repeat("a", 255), strings.Repeat("a", 255)},
+		{"too long", strings.Repeat("a", 256), strings.Repeat("a", 254) + "…"},
+		{"multibyte within max length", strings.Repeat("中", 200), strings.Repeat("中", 200)},
+		{"multibyte too long", strings.Repeat("中", 300), strings.Repeat("中", 254) + "…"},
+		{"emoji too long", strings.Repeat("🐛", 256), strings.Repeat("🐛", 254) + "…"},
+		{"collapsed before truncated", strings.Repeat("a  ", 200), strings.TrimSpace(strings.Repeat("a ", 127)) + "…"},
+	}
+	for _, test := range tests {
+		got := truncateSummary(test.summary)
+		if got != test.want {
+			t.Errorf("%s: truncateSummary() = %q, want %q", test.name, got, test.want)
+		}
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) > jiraSummaryMaxLength {
+			t.Errorf("%s: truncateSummary() = %q is not a valid JIRA summary", test.name, got)
+		}
+	}
+}
+
+func TestStripTitle(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		title    string
+		want     string
+	}{
+		{"no patterns", nil, "  [WIP] panic in planner ", "[WIP] panic in planner"},
+		{"prefix", []string{`^\[WIP\]`}, "[WIP]  panic in planner", "panic in planner"},
+		{"prefix not at the beginning", []string{`^\[WIP\]`}, "panic in planner [WIP]", "panic in planner [WIP]"},
+		{"all patterns in order", []string{`(?i)^\[?bug\]?:?`, `\s*\(#\d+\)$`}, "Bug: panic in planner (#123)", "panic in planner"},
+		{"whole title", []string{`.*`}, "panic in planner", ""},
+	}
+	for _, test := range tests {
+		templates, err := parseJiraTemplates(RepoConfig{SummaryStripPatterns: test.patterns})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := templates.stripTitle(test.title); got != test.want {
+			t.Errorf("%s: stripTitle(%q) = %q, want %q", test.name, test.title, got, test.want)
+		}
+	}
+}
+
+func TestLabelTag(t *testing.T) {
+	tags := map[string]string{
+		"type/bug":     "[BUG]",
+		"type/feature": "[FEATURE]",
+		"priority/P0":  "[P0]",
+	}
+	tests := []struct {
+		name   string
+		labels []string
+		want   string
+	}{
+		{"no labels", nil, ""},
+		{"no tagged labels", []string{"sig/planner"}, ""},
+		{"tagged label", []string{"sig/planner", "type/bug"}, "[BUG]"},
+		{"first tagged label in GitHub order wins", []string{"priority/P0", "type/bug"}, "[P0]"},
+		{"first tagged label in GitHub order wins reversed", []string{"type/bug", "priority/P0"}, "[BUG]"},
+		{"label names are case sensitive", []string{"Type/Bug"}, ""},
+	}
+	templates, err := parseJiraTemplates(RepoConfig{SummaryLabelTags: tags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		if got := templates.labelTag(test.labels); got != test.want {
+			t.Errorf("%s: labelTag(%v) = %q, want %q", test.name, test.labels, got, test.want)
+		}
+	}
+}
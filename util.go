@@ -2,8 +2,9 @@ package main
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
-	"time"
 
 	logrus "github.com/sirupsen/logrus"
 )
@@ -25,38 +26,51 @@ func logrusSetLevelByString(logLevel string) {
 	}
 }
 
-// read lastSyncTime from file and sync from the day before lastSyncTime, if not, sync all issues in last three months
-func (s *Server) readLastSyncTime(l *logrus.Entry) {
-	if s.Config.UseLastSyncTimeFile {
-		b, err := ioutil.ReadFile(lastSyncTimeFileName)
-		if err != nil {
-			l.WithError(err).Debugf("read %s, sync all issues in last three months", lastSyncTimeFileName)
-		} else {
-			lastSyncTime, err := time.Parse(time.RFC3339, string(b[:]))
-			if err != nil {
-				l.WithError(err).Info("parse time error")
-			} else {
-				l.Debug("lastSyncTime : ", lastSyncTime)
-				s.Config.GithubIssueSince = lastSyncTime.AddDate(0, 0, -1)
-			}
+// shrinkString shrink too long string into maxLength
+func shrinkString(str string, maxLength int) string {
+	if len(str) > maxLength {
+		return str[:maxLength] + "\n\nNotice: The entered text is too long. It exceeds the allowed limit of 30,000 characters."
+	}
+	return str
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
 		}
 	}
+	return false
 }
 
-// write lastSyncTime to file, which can be read next time
-func (s *Server) writeLastSyncTime(l *logrus.Entry) {
-	b := []byte(time.Now().Format(time.RFC3339))
-	err := ioutil.WriteFile(lastSyncTimeFileName, b, 0644)
-	if err != nil {
-		l.WithError(err).Debugf("write \"%s\" error", lastSyncTimeFileName)
+// sameStrings reports whether two lists have the same strings regardless of order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	l.Debugf("write lastSyncTime into \"%s\"", lastSyncTimeFileName)
+	for _, v := range a {
+		if !containsString(b, v) {
+			return false
+		}
+	}
+	return true
 }
 
-// shrinkString shrink too long string into maxLength
-func shrinkString(str string, maxLength int) string {
-	if len(str) > maxLength {
-		return str[:maxLength] + "\n\nNotice: The entered text is too long. It exceeds the allowed limit of 30,000 characters."
+// writeFileAtomic writes b to a temporary file in the same directory and renames
+// it to path, so that a crash never leaves a truncated file
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
 	}
-	return str
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }